@@ -0,0 +1,173 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The flight catalog is seeded at startup from FLIGHTS_FILE, a JSON array of
+// flights or a CSV file with a flight,day,value header and one seats_<cabin>
+// column per cabin, or from the embedded flights.json when it is not set.
+// The search metadata (airline, origin, destination, departure and arrival
+// columns) is optional: flights without it can be sold but are left out of
+// search results. Departure and arrival are RFC 3339 times with the local
+// offset of each airport.
+
+//go:embed flights.json
+var defaultCatalog []byte
+
+func loadCatalog(path string) ([]Flight, error) {
+	if path == "" {
+		return parseCatalogJSON(defaultCatalog)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flight catalog: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return parseCatalogCSV(data)
+	}
+	return parseCatalogJSON(data)
+}
+
+func parseCatalogJSON(data []byte) ([]Flight, error) {
+	var catalog []Flight
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("invalid flight catalog: %w", err)
+	}
+	return catalog, validateCatalog(catalog)
+}
+
+func parseCatalogCSV(data []byte) ([]Flight, error) {
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid flight catalog: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("invalid flight catalog: missing header")
+	}
+
+	columns := make(map[string]int)
+	cabins := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		columns[name] = i
+		if cabin, ok := strings.CutPrefix(name, "seats_"); ok {
+			cabins[cabin] = i
+		}
+	}
+	for _, name := range []string{"flight", "day", "value"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("invalid flight catalog: missing column %q", name)
+		}
+	}
+	cell := func(row []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	parseTime := func(row []string, name string) (time.Time, error) {
+		value := cell(row, name)
+		if value == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339, value)
+	}
+
+	catalog := make([]Flight, 0, len(rows)-1)
+	for line, row := range rows[1:] {
+		value, err := strconv.ParseFloat(strings.TrimSpace(row[columns["value"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flight catalog: line %d: invalid value %q", line+2, row[columns["value"]])
+		}
+		seats := make(map[string]int, len(cabins))
+		for cabin, column := range cabins {
+			cell := strings.TrimSpace(row[column])
+			if cell == "" {
+				continue
+			}
+			n, err := strconv.Atoi(cell)
+			if err != nil {
+				return nil, fmt.Errorf("invalid flight catalog: line %d: invalid seats %q for %s", line+2, cell, cabin)
+			}
+			seats[cabin] = n
+		}
+		departure, err := parseTime(row, "departure")
+		if err != nil {
+			return nil, fmt.Errorf("invalid flight catalog: line %d: invalid departure %q", line+2, cell(row, "departure"))
+		}
+		arrival, err := parseTime(row, "arrival")
+		if err != nil {
+			return nil, fmt.Errorf("invalid flight catalog: line %d: invalid arrival %q", line+2, cell(row, "arrival"))
+		}
+		catalog = append(catalog, Flight{
+			Flight:      cell(row, "flight"),
+			Day:         cell(row, "day"),
+			Airline:     cell(row, "airline"),
+			Origin:      cell(row, "origin"),
+			Destination: cell(row, "destination"),
+			Departure:   departure,
+			Arrival:     arrival,
+			Value:       value,
+			Seats:       seats,
+		})
+	}
+	return catalog, validateCatalog(catalog)
+}
+
+func validateCatalog(catalog []Flight) error {
+	seen := make(map[string]bool, len(catalog))
+	for i, flight := range catalog {
+		if flight.Flight == "" {
+			return fmt.Errorf("invalid flight catalog: entry %d has no flight", i+1)
+		}
+		if _, err := time.Parse(time.DateOnly, flight.Day); err != nil {
+			return fmt.Errorf("invalid flight catalog: %s has invalid day %q", flight.Flight, flight.Day)
+		}
+		if !flight.Departure.IsZero() && flight.Departure.Format(time.DateOnly) != flight.Day {
+			return fmt.Errorf("invalid flight catalog: %s on %s departs on another day", flight.Flight, flight.Day)
+		}
+		if !flight.Departure.IsZero() && !flight.Arrival.IsZero() && !flight.Arrival.After(flight.Departure) {
+			return fmt.Errorf("invalid flight catalog: %s on %s arrives before it departs", flight.Flight, flight.Day)
+		}
+		if flight.Value <= 0 {
+			return fmt.Errorf("invalid flight catalog: %s on %s has no value", flight.Flight, flight.Day)
+		}
+		if len(flight.Seats) == 0 {
+			return fmt.Errorf("invalid flight catalog: %s on %s has no seats", flight.Flight, flight.Day)
+		}
+		for cabin, seats := range flight.Seats {
+			if cabin == "" || seats <= 0 {
+				return fmt.Errorf("invalid flight catalog: %s on %s has invalid seats for cabin %q", flight.Flight, flight.Day, cabin)
+			}
+		}
+
+		key := flightKey(flight.Flight, flight.Day)
+		if seen[key] {
+			return fmt.Errorf("invalid flight catalog: %s on %s is listed twice", flight.Flight, flight.Day)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// searchable reports whether the flight has the route and schedule a search
+// filters and sorts on.
+func (f Flight) searchable() bool {
+	return f.Airline != "" && f.Origin != "" && f.Destination != "" &&
+		!f.Departure.IsZero() && !f.Arrival.IsZero()
+}
+
+func flightKey(flight, day string) string {
+	return flight + "-" + day
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseCatalogCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		wantErr string
+	}{
+		{
+			name: "with search metadata",
+			csv: "flight,day,airline,origin,destination,departure,arrival,value,seats_economy\n" +
+				"AA123,2025-11-15,American Airlines,JFK,GRU,2025-11-15T22:30:00-05:00,2025-11-16T09:45:00-03:00,500,150\n",
+		},
+		{
+			name: "without search metadata",
+			csv:  "flight,day,value,seats_economy,seats_business\nAA123,2025-11-15,500,150,20\n",
+		},
+		{
+			name: "with empty metadata cells",
+			csv: "flight,day,airline,origin,destination,departure,arrival,value,seats_economy\n" +
+				"AA123,2025-11-15,,,,,,500,150\n",
+		},
+		{
+			name:    "without seats",
+			csv:     "flight,day,value\nAA123,2025-11-15,500\n",
+			wantErr: "has no seats",
+		},
+		{
+			name: "departing on another day",
+			csv: "flight,day,departure,value,seats_economy\n" +
+				"AA123,2025-11-15,2025-11-16T01:00:00-05:00,500,150\n",
+			wantErr: "departs on another day",
+		},
+		{
+			name: "arriving before departure",
+			csv: "flight,day,departure,arrival,value,seats_economy\n" +
+				"AA123,2025-11-15,2025-11-15T22:30:00-05:00,2025-11-15T20:00:00-05:00,500,150\n",
+			wantErr: "arrives before it departs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog, err := parseCatalogCSV([]byte(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(catalog) != 1 || catalog[0].Flight != "AA123" || catalog[0].Seats["economy"] != 150 {
+				t.Fatalf("got %+v, want AA123 with 150 economy seats", catalog)
+			}
+		})
+	}
+}
+
+func TestSearchSkipsFlightsWithoutMetadata(t *testing.T) {
+	legacy := Flight{Flight: "LA456", Day: "2025-11-15", Value: 300, Seats: map[string]int{"economy": 10}}
+	s := newMemoryStore(append([]Flight{legacy}, testCatalog...))
+
+	flights, err := s.Flights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, message := parseFlightQuery(url.Values{})
+	if message != "" {
+		t.Fatal(message)
+	}
+
+	var found []string
+	for _, flight := range flights {
+		if query.matches(flight) {
+			found = append(found, flight.Flight.Flight)
+		}
+	}
+	if len(found) != 1 || found[0] != "AA123" {
+		t.Fatalf("search found %v, want only AA123", found)
+	}
+
+	// The flight can still be looked up and sold.
+	if _, _, err := s.Flight("LA456", "2025-11-15"); err != nil {
+		t.Fatalf("lookup of a flight without metadata: %v", err)
+	}
+}
@@ -3,3 +3,7 @@ module airlineshub
 go 1.25
 
 require github.com/google/uuid v1.6.0
+
+require platform v0.0.0
+
+replace platform => ../platform
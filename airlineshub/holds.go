@@ -0,0 +1,227 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"platform"
+)
+
+// A purchase can hold a seat with /reserve and decide later: /confirm turns
+// the hold into a sale under the same ID, /release gives the seat back.
+// Holds not confirmed within HOLD_TTL are released by a background sweeper.
+
+var (
+	holdTTL           = durationFromEnv("HOLD_TTL", time.Minute)
+	holdSweepInterval = durationFromEnv("HOLD_SWEEP_INTERVAL", 5*time.Second)
+)
+
+type ReserveRequest struct {
+	Flight    string `json:"flight"`
+	Day       string `json:"day"`
+	Cabin     string `json:"cabin,omitempty"`
+	Reference string `json:"reference,omitempty"`
+}
+
+type HoldRequest struct {
+	HoldID string `json:"hold_id"`
+}
+
+type HoldResponse struct {
+	HoldID    string    `json:"hold_id"`
+	Status    string    `json:"status"`
+	Cabin     string    `json:"cabin"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+func durationFromEnv(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func reserveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReserveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Flight == "" || req.Day == "" {
+		respondError(w, "Missing required fields: flight and day", http.StatusBadRequest)
+		return
+	}
+
+	cabin := cmp.Or(req.Cabin, defaultCabin)
+	now := time.Now()
+	hold, duplicate, err := store.Sell(Transaction{
+		ID:        uuid.New().String(),
+		Reference: req.Reference,
+		Flight:    req.Flight,
+		Day:       req.Day,
+		Cabin:     cabin,
+		Date:      now,
+		Status:    statusHeld,
+		ExpiresAt: now.Add(holdTTL),
+	})
+	switch {
+	case errors.Is(err, errFlightNotFound):
+		respondError(w, "Flight not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errUnknownCabin):
+		respondError(w, "Unknown cabin", http.StatusBadRequest)
+		return
+	case errors.Is(err, errReferenceConflict):
+		logger.InfoContext(r.Context(), "hold rejected, reference already used",
+			"reference", req.Reference, "flight", req.Flight, "day", req.Day, "cabin", cabin)
+		respondError(w, "Reference already used for another sale", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, errSoldOut):
+		logger.InfoContext(r.Context(), "hold rejected, cabin sold out",
+			"flight", req.Flight, "day", req.Day, "cabin", cabin)
+		respondError(w, "Sold out", http.StatusConflict)
+		return
+	case err != nil:
+		logger.ErrorContext(r.Context(), "failed to store hold", "error", err)
+		respondError(w, "Failed to store hold", http.StatusInternalServerError)
+		return
+	}
+
+	response := HoldResponse{HoldID: hold.ID, Status: hold.Status, Cabin: hold.Cabin, ExpiresAt: hold.ExpiresAt}
+	if duplicate {
+		logger.InfoContext(r.Context(), "duplicate hold, returning original",
+			"reference", req.Reference, "hold_id", hold.ID, "status", hold.Status)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "seat held",
+		"hold_id", hold.ID, "reference", req.Reference, "flight", req.Flight, "day", req.Day, "cabin", cabin,
+		"expires_at", hold.ExpiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func confirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req HoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.HoldID == "" {
+		respondError(w, "Missing required field: hold_id", http.StatusBadRequest)
+		return
+	}
+
+	injectSellLatency(r.Context())
+
+	transaction, err := store.Confirm(req.HoldID, time.Now())
+	switch {
+	case errors.Is(err, errTransactionNotFound):
+		respondError(w, "Hold not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errHoldNotActive):
+		logger.InfoContext(r.Context(), "confirmation rejected, hold no longer active", "hold_id", req.HoldID)
+		respondError(w, "Hold expired or released", http.StatusGone)
+		return
+	case err != nil:
+		logger.ErrorContext(r.Context(), "failed to store confirmation", "error", err)
+		respondError(w, "Failed to store confirmation", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "hold confirmed, ticket sold",
+		"transaction_id", transaction.ID, "reference", transaction.Reference,
+		"flight", transaction.Flight, "day", transaction.Day, "cabin", transaction.Cabin)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SellResponse{ID: transaction.ID})
+}
+
+func releaseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req HoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.HoldID == "" {
+		respondError(w, "Missing required field: hold_id", http.StatusBadRequest)
+		return
+	}
+
+	hold, err := store.Release(req.HoldID)
+	switch {
+	case errors.Is(err, errTransactionNotFound):
+		respondError(w, "Hold not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errHoldConfirmed):
+		respondError(w, "Hold already confirmed, cancel the sale instead", http.StatusConflict)
+		return
+	case err != nil:
+		logger.ErrorContext(r.Context(), "failed to store release", "error", err)
+		respondError(w, "Failed to store release", http.StatusInternalServerError)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "hold released",
+		"hold_id", hold.ID, "flight", hold.Flight, "day", hold.Day, "status", hold.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HoldResponse{HoldID: hold.ID, Status: hold.Status, Cabin: hold.Cabin})
+}
+
+// sweepHolds releases expired holds every HOLD_SWEEP_INTERVAL until ctx is
+// done.
+func sweepHolds(ctx context.Context) {
+	ticker := time.NewTicker(holdSweepInterval)
+	defer ticker.Stop()
+	logger.Info("hold sweeper started", "ttl_ms", platform.DurationMillis(holdTTL))
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("hold sweeper stopped")
+			return
+		case <-ticker.C:
+		}
+
+		released, err := store.ReleaseExpired(time.Now())
+		if err != nil {
+			logger.Error("failed to release expired holds", "error", err)
+		}
+		for _, hold := range released {
+			logger.Info("expired hold released",
+				"hold_id", hold.ID, "reference", hold.Reference, "flight", hold.Flight, "day", hold.Day)
+		}
+	}
+}
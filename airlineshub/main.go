@@ -1,64 +1,161 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
-	"log"
-	"math/rand/v2"
+	"errors"
+	"flag"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"platform"
 )
 
 type Flight struct {
-	Flight string  `json:"flight"`
-	Day    string  `json:"day"`
-	Value  float64 `json:"value"`
+	Flight      string    `json:"flight"`
+	Day         string    `json:"day"`
+	Airline     string    `json:"airline,omitempty"`
+	Origin      string    `json:"origin,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Departure   time.Time `json:"departure,omitzero"`
+	Arrival     time.Time `json:"arrival,omitzero"`
+	Value       float64   `json:"value"`
+	// Seats is the capacity of each cabin.
+	Seats map[string]int `json:"seats"`
+}
+
+// FlightResponse is a flight with the seats still available in each cabin.
+type FlightResponse struct {
+	Flight
+	Available map[string]int `json:"available"`
 }
 
 type SellRequest struct {
-	Flight string `json:"flight"`
-	Day    string `json:"day"`
+	Flight    string `json:"flight"`
+	Day       string `json:"day"`
+	Cabin     string `json:"cabin,omitempty"`
+	Reference string `json:"reference,omitempty"`
 }
 
 type SellResponse struct {
 	ID string `json:"id"`
 }
 
+type CancelRequest struct {
+	ID string `json:"id"`
+}
+
+type CancelResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
 type Transaction struct {
-	ID     string
-	Flight string
-	Day    string
-	Date   time.Time
+	ID          string    `json:"id"`
+	Reference   string    `json:"reference,omitempty"`
+	Flight      string    `json:"flight"`
+	Day         string    `json:"day"`
+	Cabin       string    `json:"cabin"`
+	Date        time.Time `json:"date"`
+	Status      string    `json:"status"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	ReleasedAt  time.Time `json:"released_at,omitzero"`
+	CancelledAt time.Time `json:"cancelled_at,omitzero"`
 }
 
+const (
+	statusHeld      = "held"
+	statusSold      = "sold"
+	statusReleased  = "released"
+	statusCancelled = "cancelled"
+
+	// defaultCabin is sold when a request does not name a cabin.
+	defaultCabin = "economy"
+)
+
 var (
-	flights = map[string]Flight{
-		"AA123-2025-11-15": {Flight: "AA123", Day: "2025-11-15", Value: 500.00},
-		"AA123-2025-11-20": {Flight: "AA123", Day: "2025-11-20", Value: 550.00},
-		"BA456-2025-11-15": {Flight: "BA456", Day: "2025-11-15", Value: 750.00},
-		"BA456-2025-12-01": {Flight: "BA456", Day: "2025-12-01", Value: 800.00},
-		"LA789-2025-11-25": {Flight: "LA789", Day: "2025-11-25", Value: 450.00},
-		"LA789-2025-12-10": {Flight: "LA789", Day: "2025-12-10", Value: 480.00},
-		"UA999-2025-11-30": {Flight: "UA999", Day: "2025-11-30", Value: 920.00},
-		"DL555-2025-12-05": {Flight: "DL555", Day: "2025-12-05", Value: 680.00},
-	}
-	transactions   = make(map[string]Transaction)
-	mu             sync.RWMutex
-	faultR3Mutex   sync.Mutex
-	faultR3Active  bool
-	faultR3EndTime time.Time
+	logger = platform.Init("airlineshub")
+
+	store Store
+
+	faults = platform.NewFaultInjector(
+		platform.Fault{Name: "flight_omission", Endpoint: "/flight", Type: platform.FaultOmission, Enabled: true, Probability: 0.2},
+		platform.Fault{Name: "sell_latency", Endpoint: "/sell,/confirm", Type: platform.FaultLatency, Enabled: true, Probability: 0.1,
+			Duration: 10 * time.Second, Delay: 5 * time.Second},
+	)
 )
 
 func main() {
-	http.HandleFunc("/flight", getFlightHandler)
-	http.HandleFunc("/sell", sellTicketHandler)
-	http.HandleFunc("/health", healthHandler)
+	healthcheck := flag.Bool("healthcheck", false, "check /livez of the running service and exit")
+	flag.Parse()
 
 	port := ":8081"
-	log.Printf("AirlinesHub service starting on port %s", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	if *healthcheck {
+		os.Exit(platform.RunHealthcheck(port))
+	}
+
+	http.HandleFunc("/flight", platform.Instrument("/flight", platform.Traced(getFlightHandler)))
+	http.HandleFunc("/flights", platform.Instrument("/flights", platform.Traced(searchFlightsHandler)))
+	http.HandleFunc("/sell", platform.Instrument("/sell", platform.Traced(sellTicketHandler)))
+	http.HandleFunc("/reserve", platform.Instrument("/reserve", platform.Traced(reserveHandler)))
+	http.HandleFunc("/confirm", platform.Instrument("/confirm", platform.Traced(confirmHandler)))
+	http.HandleFunc("/release", platform.Instrument("/release", platform.Traced(releaseHandler)))
+	http.HandleFunc("/cancel", platform.Instrument("/cancel", platform.Traced(cancelTicketHandler)))
+	http.HandleFunc("/transactions", platform.Instrument("/transactions", platform.Traced(platform.AdminOnly(listTransactionsHandler))))
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/livez", platform.LivezHandler)
+	http.HandleFunc("/readyz", platform.ReadyzHandler)
+	http.HandleFunc("/metrics", platform.MetricsHandler)
+	faults.HandleFaults()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	store, err = openStore()
+	if err != nil {
+		logger.Error("failed to open store", "error", err)
+		os.Exit(1)
+	}
+
+	platform.StartTracing()
+	platform.Ready.Store(true)
+
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		sweepHolds(ctx)
+	}()
+
+	logger.Info("service starting", "port", port)
+	err = platform.Serve(ctx, port)
+	if err != nil {
+		logger.Error("server stopped", "error", err)
+	}
+
+	stop()
+	<-sweeperDone
+	if err := store.Close(); err != nil {
+		logger.Error("failed to close store", "error", err)
+	}
+	platform.StopTracing()
+	logger.Info("service stopped")
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func getEnv(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -67,12 +164,6 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func getFlightHandler(w http.ResponseWriter, r *http.Request) {
-	if rand.Float64() < 0.2 {
-		log.Printf("!!! FALHA SIMULADA (Omission): Request 1 (/flight) não irá responder.")
-		<-make(chan bool)
-		return
-	}
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -86,22 +177,27 @@ func getFlightHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := flightNumber + "-" + day
-
-	mu.RLock()
-	flight, exists := flights[key]
-	mu.RUnlock()
+	// Only valid queries count towards the fault, so a malformed request
+	// does not shift the requests a schedule targets.
+	if fault, ok := faults.Trigger("flight_omission"); ok {
+		logger.WarnContext(r.Context(), "simulated fault: request will not be answered",
+			"fault", fault.Type, "flight", flightNumber, "day", day)
+		// Never answer, but let go once the caller gives up so shutdown can drain.
+		<-r.Context().Done()
+		return
+	}
 
-	if !exists {
+	flight, available, err := store.Flight(flightNumber, day)
+	if err != nil {
 		respondError(w, "Flight not found", http.StatusNotFound)
 		return
 	}
 
-	log.Printf("Flight query: %s on %s - Value: $%.2f", flightNumber, day, flight.Value)
+	logger.InfoContext(r.Context(), "flight query", "flight", flightNumber, "day", day, "value", flight.Value)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(flight)
+	json.NewEncoder(w).Encode(FlightResponse{Flight: flight, Available: available})
 }
 
 func sellTicketHandler(w http.ResponseWriter, r *http.Request) {
@@ -110,78 +206,151 @@ func sellTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const (
-		probR3     = 0.1
-		effectR3   = 5 * time.Second
-		durationR3 = 10 * time.Second
-	)
+	var req SellRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-	applyR3Fault := false
-	faultR3Mutex.Lock()
-	now := time.Now()
+	if req.Flight == "" || req.Day == "" {
+		respondError(w, "Missing required fields: flight and day", http.StatusBadRequest)
+		return
+	}
 
-	if faultR3Active && now.Before(faultR3EndTime) {
-		log.Println("[FAULT] Request 3: Time fault STATE active. Applying 5s delay.")
-		applyR3Fault = true
-	} else {
-		faultR3Active = false
+	injectSellLatency(r.Context())
+
+	cabin := cmp.Or(req.Cabin, defaultCabin)
+	transaction, duplicate, err := store.Sell(Transaction{
+		ID:        uuid.New().String(),
+		Reference: req.Reference,
+		Flight:    req.Flight,
+		Day:       req.Day,
+		Cabin:     cabin,
+		Date:      time.Now(),
+		Status:    statusSold,
+	})
+	switch {
+	case errors.Is(err, errFlightNotFound):
+		respondError(w, "Flight not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errUnknownCabin):
+		respondError(w, "Unknown cabin", http.StatusBadRequest)
+		return
+	case errors.Is(err, errReferenceConflict):
+		logger.InfoContext(r.Context(), "sale rejected, reference already used",
+			"reference", req.Reference, "flight", req.Flight, "day", req.Day, "cabin", cabin)
+		respondError(w, "Reference already used for another sale", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, errSoldOut):
+		logger.InfoContext(r.Context(), "sale rejected, cabin sold out",
+			"flight", req.Flight, "day", req.Day, "cabin", cabin)
+		respondError(w, "Sold out", http.StatusConflict)
+		return
+	case err != nil:
+		logger.ErrorContext(r.Context(), "failed to store sale", "error", err)
+		respondError(w, "Failed to store sale", http.StatusInternalServerError)
+		return
+	}
 
-		if rand.Float64() < probR3 {
-			log.Println("[FAULT] Request 3: Time fault TRIGGERED. State active for 10s.")
-			faultR3Active = true
-			faultR3EndTime = now.Add(durationR3)
-			applyR3Fault = true
-		}
+	if duplicate {
+		logger.InfoContext(r.Context(), "duplicate sale, returning original transaction",
+			"reference", req.Reference, "transaction_id", transaction.ID, "flight", req.Flight, "day", req.Day)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SellResponse{ID: transaction.ID})
+		return
 	}
-	faultR3Mutex.Unlock()
 
-	if applyR3Fault {
-		time.Sleep(effectR3)
+	logger.InfoContext(r.Context(), "ticket sold",
+		"transaction_id", transaction.ID, "reference", req.Reference, "flight", req.Flight, "day", req.Day, "cabin", transaction.Cabin)
+
+	response := SellResponse{
+		ID: transaction.ID,
 	}
 
-	var req SellRequest
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func cancelTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CancelRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if req.Flight == "" || req.Day == "" {
-		respondError(w, "Missing required fields: flight and day", http.StatusBadRequest)
+	if req.ID == "" {
+		respondError(w, "Missing required field: id", http.StatusBadRequest)
 		return
 	}
 
-	key := req.Flight + "-" + req.Day
-	mu.RLock()
-	_, exists := flights[key]
-	mu.RUnlock()
-
-	if !exists {
-		respondError(w, "Flight not found", http.StatusNotFound)
+	transaction, err := store.Cancel(req.ID)
+	if errors.Is(err, errTransactionNotFound) {
+		respondError(w, "Transaction not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to store cancellation", "error", err)
+		respondError(w, "Failed to store cancellation", http.StatusInternalServerError)
+		return
+	}
+	logger.InfoContext(r.Context(), "ticket cancelled",
+		"transaction_id", transaction.ID, "flight", transaction.Flight, "day", transaction.Day, "status", transaction.Status)
 
-	transactionID := uuid.New().String()
-
-	transaction := Transaction{
-		ID:     transactionID,
-		Flight: req.Flight,
-		Day:    req.Day,
-		Date:   time.Now(),
+	response := CancelResponse{
+		ID:     transaction.ID,
+		Status: transaction.Status,
 	}
 
-	mu.Lock()
-	transactions[transactionID] = transaction
-	mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// listTransactionsHandler lists the transactions in sale order, optionally
+// only those with the given status. It is only open to the admin token.
+func listTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	log.Printf("Ticket sold: transaction_id=%s, flight=%s, day=%s", transactionID, req.Flight, req.Day)
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains([]string{statusHeld, statusSold, statusReleased, statusCancelled}, status) {
+		respondError(w, "Invalid status: must be held, sold, released or cancelled", http.StatusBadRequest)
+		return
+	}
 
-	response := SellResponse{
-		ID: transactionID,
+	transactions, err := store.Transactions()
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to list transactions", "error", err)
+		respondError(w, "Failed to list transactions", http.StatusInternalServerError)
+		return
 	}
+	list := slices.DeleteFunc(transactions, func(transaction Transaction) bool {
+		return status != "" && transaction.Status != status
+	})
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
+
+// injectSellLatency delays a sale, through /sell or /confirm, when the
+// sell_latency fault fires. Handlers call it once the request is valid.
+func injectSellLatency(ctx context.Context) {
+	if fault, ok := faults.Trigger("sell_latency"); ok {
+		logger.WarnContext(ctx, "simulated fault: delaying response",
+			"fault", fault.Type, "delay_ms", platform.DurationMillis(fault.Delay))
+		platform.AddSpanEvent(ctx, "fault", map[string]string{"type": fault.Type, "delay": fault.Delay.String()})
+		time.Sleep(fault.Delay)
+	}
 }
 
 func respondError(w http.ResponseWriter, message string, statusCode int) {
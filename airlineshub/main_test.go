@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useTestStore gives the test an empty in-memory store over the default
+// catalog.
+func useTestStore(t *testing.T) {
+	t.Helper()
+
+	catalog, err := loadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := store
+	store = newMemoryStore(catalog)
+	t.Cleanup(func() { store = previous })
+}
+
+func TestSellReference(t *testing.T) {
+	useTestStore(t)
+	call := func(handler http.HandlerFunc, body string) (int, string) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		var response struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&response)
+		return w.Code, response.ID
+	}
+	const sale = `{"flight": "AA123", "day": "2025-11-15", "reference": "r1"}`
+
+	status, first := call(sellTicketHandler, sale)
+	if status != http.StatusCreated || first == "" {
+		t.Fatalf("sale: %d %q", status, first)
+	}
+	if status, id := call(sellTicketHandler, sale); status != http.StatusOK || id != first {
+		t.Fatalf("repeated sale: %d %q, want 200 %q", status, id, first)
+	}
+	if status, _ := call(sellTicketHandler, `{"flight": "AA123", "day": "2025-11-20", "reference": "r1"}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("reference reused for another flight: %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	// Once cancelled, the reference no longer holds a seat: a retry buys a
+	// new one.
+	if status, _ := call(cancelTicketHandler, `{"id": "`+first+`"}`); status != http.StatusOK {
+		t.Fatalf("cancel: %d", status)
+	}
+	if status, id := call(sellTicketHandler, sale); status != http.StatusCreated || id == first {
+		t.Fatalf("sale after cancelling: %d %q, want a new transaction", status, id)
+	}
+
+	_, a := call(sellTicketHandler, `{"flight": "AA123", "day": "2025-11-15"}`)
+	_, b := call(sellTicketHandler, `{"flight": "AA123", "day": "2025-11-15"}`)
+	if a == b {
+		t.Fatal("sales without a reference were merged")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxPage         = 10000
+)
+
+// FlightQuery filters and orders a flight search. Dates compare against the
+// day of departure; MaxPrice is in USD.
+type FlightQuery struct {
+	Origin      string
+	Destination string
+	Airline     string
+	From        string
+	To          string
+	MaxPrice    float64
+	Cabin       string
+	Sort        string
+	Page        int
+	PageSize    int
+}
+
+type SearchResponse struct {
+	Flights  []FlightResponse `json:"flights"`
+	Total    int              `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+}
+
+// flightOrders are the accepted values of sort. A leading "-" reverses the
+// order.
+var flightOrders = map[string]func(a, b FlightResponse) int{
+	"departure": func(a, b FlightResponse) int { return a.Departure.Compare(b.Departure) },
+	"price":     func(a, b FlightResponse) int { return cmp.Compare(a.Value, b.Value) },
+	"duration": func(a, b FlightResponse) int {
+		return cmp.Compare(a.Arrival.Sub(a.Departure), b.Arrival.Sub(b.Departure))
+	},
+}
+
+// searchFlightsHandler lists the flights matching the query parameters
+// origin, destination, airline, from, to, max_price and cabin, ordered by
+// sort and split in pages.
+func searchFlightsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, message := parseFlightQuery(r.URL.Query())
+	if message != "" {
+		respondError(w, message, http.StatusBadRequest)
+		return
+	}
+
+	flights, err := store.Flights()
+	if err != nil {
+		logger.ErrorContext(r.Context(), "failed to list flights", "error", err)
+		respondError(w, "Failed to list flights", http.StatusInternalServerError)
+		return
+	}
+	response := searchFlights(flights, query)
+
+	logger.InfoContext(r.Context(), "flight search",
+		"origin", query.Origin, "destination", query.Destination, "from", query.From, "to", query.To,
+		"matches", response.Total)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// searchFlights filters and orders flights by query and returns the
+// requested page. A page past the last one is empty.
+func searchFlights(flights []FlightResponse, query FlightQuery) SearchResponse {
+	flights = slices.DeleteFunc(flights, func(flight FlightResponse) bool {
+		return !query.matches(flight)
+	})
+
+	order := flightOrders[strings.TrimPrefix(query.Sort, "-")]
+	slices.SortFunc(flights, func(a, b FlightResponse) int {
+		c := order(a, b)
+		if strings.HasPrefix(query.Sort, "-") {
+			c = -c
+		}
+		return cmp.Or(c, cmp.Compare(a.Flight.Flight, b.Flight.Flight), cmp.Compare(a.Day, b.Day))
+	})
+
+	response := SearchResponse{
+		Flights:  []FlightResponse{},
+		Total:    len(flights),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}
+	// Compare page numbers rather than offsets so a huge page cannot
+	// overflow (page-1)*page_size.
+	pages := (len(flights) + query.PageSize - 1) / query.PageSize
+	if query.Page-1 < pages {
+		start := (query.Page - 1) * query.PageSize
+		response.Flights = flights[start:min(start+query.PageSize, len(flights))]
+	}
+	return response
+}
+
+// parseFlightQuery reads a FlightQuery, or returns the reason it is invalid.
+func parseFlightQuery(values url.Values) (FlightQuery, string) {
+	query := FlightQuery{
+		Origin:      strings.ToUpper(values.Get("origin")),
+		Destination: strings.ToUpper(values.Get("destination")),
+		Airline:     values.Get("airline"),
+		From:        values.Get("from"),
+		To:          values.Get("to"),
+		Cabin:       values.Get("cabin"),
+		Sort:        cmp.Or(values.Get("sort"), "departure"),
+		Page:        1,
+		PageSize:    defaultPageSize,
+	}
+
+	for name, day := range map[string]string{"from": query.From, "to": query.To} {
+		if _, err := time.Parse(time.DateOnly, day); day != "" && err != nil {
+			return query, "Invalid " + name + ": must be a date like 2025-11-15"
+		}
+	}
+	if query.From != "" && query.To != "" && query.To < query.From {
+		return query, "Invalid date range: to is before from"
+	}
+	if value := values.Get("max_price"); value != "" {
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price <= 0 {
+			return query, "Invalid max_price: must be a positive number"
+		}
+		query.MaxPrice = price
+	}
+	if _, ok := flightOrders[strings.TrimPrefix(query.Sort, "-")]; !ok {
+		return query, "Invalid sort: must be departure, price or duration, optionally prefixed with -"
+	}
+	if value := values.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 || page > maxPage {
+			return query, "Invalid page: must be between 1 and " + strconv.Itoa(maxPage)
+		}
+		query.Page = page
+	}
+	if value := values.Get("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > maxPageSize {
+			return query, "Invalid page_size: must be between 1 and " + strconv.Itoa(maxPageSize)
+		}
+		query.PageSize = size
+	}
+	return query, ""
+}
+
+func (q FlightQuery) matches(flight FlightResponse) bool {
+	switch {
+	case !flight.searchable():
+		return false
+	case q.Origin != "" && flight.Origin != q.Origin:
+		return false
+	case q.Destination != "" && flight.Destination != q.Destination:
+		return false
+	case q.Airline != "" && !strings.EqualFold(flight.Airline, q.Airline):
+		return false
+	case q.From != "" && flight.Day < q.From:
+		return false
+	case q.To != "" && flight.Day > q.To:
+		return false
+	case q.MaxPrice > 0 && flight.Value > q.MaxPrice:
+		return false
+	case q.Cabin != "" && flight.Available[q.Cabin] == 0:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// searchFixture returns five JFK flights, one a day from 2025-11-11, each
+// $100 dearer than the last. The last has no economy seats left and the
+// third flies to LIS instead of GRU.
+func searchFixture() []FlightResponse {
+	var flights []FlightResponse
+	for i := range 5 {
+		departure := time.Date(2025, 11, 11+i, 22, 0, 0, 0, time.UTC)
+		flight := FlightResponse{
+			Flight: Flight{
+				Flight:      fmt.Sprintf("AA%d", 100+i),
+				Day:         departure.Format(time.DateOnly),
+				Airline:     "American Airlines",
+				Origin:      "JFK",
+				Destination: "GRU",
+				Departure:   departure,
+				Arrival:     departure.Add(10 * time.Hour),
+				Value:       float64(100 * (i + 1)),
+				Seats:       map[string]int{"economy": 2},
+			},
+			Available: map[string]int{"economy": 2},
+		}
+		if i == 2 {
+			flight.Destination = "LIS"
+		}
+		if i == 4 {
+			flight.Available["economy"] = 0
+		}
+		flights = append(flights, flight)
+	}
+	return flights
+}
+
+func TestSearchFlights(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     url.Values
+		want      []string
+		wantTotal int
+	}{
+		{"everything", url.Values{}, []string{"AA100", "AA101", "AA102", "AA103", "AA104"}, 5},
+		{"by destination", url.Values{"destination": {"gru"}}, []string{"AA100", "AA101", "AA103", "AA104"}, 4},
+		{"by airline", url.Values{"airline": {"american airlines"}}, []string{"AA100", "AA101", "AA102", "AA103", "AA104"}, 5},
+		{"by date range", url.Values{"from": {"2025-11-12"}, "to": {"2025-11-13"}}, []string{"AA101", "AA102"}, 2},
+		{"by max price", url.Values{"max_price": {"250"}}, []string{"AA100", "AA101"}, 2},
+		{"by cabin", url.Values{"cabin": {"economy"}}, []string{"AA100", "AA101", "AA102", "AA103"}, 4},
+		{"most expensive first", url.Values{"sort": {"-price"}, "page_size": {"2"}}, []string{"AA104", "AA103"}, 5},
+		{"first page", url.Values{"page_size": {"2"}}, []string{"AA100", "AA101"}, 5},
+		{"last page", url.Values{"page": {"3"}, "page_size": {"2"}}, []string{"AA104"}, 5},
+		{"page past the end", url.Values{"page": {"4"}, "page_size": {"2"}}, nil, 5},
+		{"last allowed page", url.Values{"page": {strconv.Itoa(maxPage)}, "page_size": {strconv.Itoa(maxPageSize)}}, nil, 5},
+		{"no match", url.Values{"origin": {"GRU"}}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, message := parseFlightQuery(tt.query)
+			if message != "" {
+				t.Fatal(message)
+			}
+			response := searchFlights(searchFixture(), query)
+
+			var found []string
+			for _, flight := range response.Flights {
+				found = append(found, flight.Flight.Flight)
+			}
+			if !slices.Equal(found, tt.want) {
+				t.Fatalf("found %v, want %v", found, tt.want)
+			}
+			if response.Total != tt.wantTotal {
+				t.Fatalf("total %d, want %d", response.Total, tt.wantTotal)
+			}
+			if response.Flights == nil {
+				t.Fatal("flights is nil, want an empty list")
+			}
+		})
+	}
+}
+
+func TestSearchFlightsHugePage(t *testing.T) {
+	// A page past maxPage is rejected, and one that slips through must
+	// not overflow into a negative offset.
+	for _, page := range []string{strconv.Itoa(maxPage + 1), "9223372036854775807"} {
+		if _, message := parseFlightQuery(url.Values{"page": {page}}); message == "" {
+			t.Fatalf("page %s accepted, want it rejected", page)
+		}
+	}
+
+	query, _ := parseFlightQuery(url.Values{})
+	query.Page = 1<<63 - 1
+	if response := searchFlights(searchFixture(), query); len(response.Flights) != 0 {
+		t.Fatalf("page %d returned %d flights, want none", query.Page, len(response.Flights))
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"platform"
+)
+
+var (
+	errFlightNotFound      = errors.New("flight not found")
+	errTransactionNotFound = errors.New("transaction not found")
+	errUnknownCabin        = errors.New("unknown cabin")
+	errSoldOut             = errors.New("sold out")
+	errHoldNotActive       = errors.New("hold expired or released")
+	errHoldConfirmed       = errors.New("hold already confirmed")
+	errReferenceConflict   = errors.New("reference already used")
+)
+
+// Store keeps the flight catalog and the sales. STORAGE selects the
+// implementation: "file" (default) persists sales under DATA_DIR, "memory"
+// keeps them only for the life of the process.
+type Store interface {
+	// Flight returns a flight and the seats still available in each cabin.
+	Flight(flight, day string) (Flight, map[string]int, error)
+	// Flights lists the catalog, in no particular order, with the seats
+	// available in each cabin.
+	Flights() ([]FlightResponse, error)
+	// Sell records transaction, a sale or a hold depending on its Status,
+	// unless one with the same non-empty Reference exists, in which case
+	// that one is returned with true while it still takes its seat; once
+	// released or cancelled, the reference takes a seat again. A reference
+	// already used for another seat fails with errReferenceConflict. It fails
+	// with errSoldOut when the cabin has no seats left.
+	Sell(transaction Transaction) (Transaction, bool, error)
+	// Confirm turns a hold into a sale. Confirming a sale changes nothing;
+	// a hold past its expiry is released and fails with errHoldNotActive.
+	Confirm(id string, now time.Time) (Transaction, error)
+	// Release gives the seat of a hold back. It fails with errHoldConfirmed
+	// once the hold became a sale, which has to be cancelled instead.
+	Release(id string) (Transaction, error)
+	// ReleaseExpired releases the holds that expired before now.
+	ReleaseExpired(now time.Time) ([]Transaction, error)
+	// Cancel marks a sale cancelled and gives its seat back; a hold is
+	// released. Cancelling it again changes nothing.
+	Cancel(id string) (Transaction, error)
+	// Transactions lists the sales in the order they were made.
+	Transactions() ([]Transaction, error)
+	Close() error
+}
+
+func openStore() (Store, error) {
+	catalog, err := loadCatalog(os.Getenv("FLIGHTS_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	hidden := 0
+	for _, flight := range catalog {
+		if !flight.searchable() {
+			hidden++
+		}
+	}
+	if hidden > 0 {
+		logger.Warn("flights without airline, route or schedule are left out of search", "flights", hidden)
+	}
+
+	switch kind := getEnv("STORAGE", "file"); kind {
+	case "memory":
+		return newMemoryStore(catalog), nil
+	case "file":
+		return openFileStore(filepath.Join(getEnv("DATA_DIR", "data"), "transactions.log"), catalog)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q: must be file or memory", kind)
+	}
+}
+
+type memoryStore struct {
+	mu           sync.RWMutex
+	flights      map[string]Flight
+	transactions map[string]Transaction
+	references   map[string]string
+	order        []string
+	// sold counts the seats taken by sales and holds of each flight and
+	// cabin.
+	sold map[string]map[string]int
+	// holds are the IDs of the active holds.
+	holds map[string]bool
+
+	// persist, when set, is called with every changed transaction before
+	// the change is applied, and aborts it on error.
+	persist func(Transaction) error
+}
+
+func newMemoryStore(catalog []Flight) *memoryStore {
+	s := &memoryStore{
+		flights:      make(map[string]Flight, len(catalog)),
+		transactions: make(map[string]Transaction),
+		references:   make(map[string]string),
+		sold:         make(map[string]map[string]int),
+		holds:        make(map[string]bool),
+	}
+	for _, flight := range catalog {
+		s.flights[flightKey(flight.Flight, flight.Day)] = flight
+	}
+	return s
+}
+
+func (s *memoryStore) Flight(flight, day string) (Flight, map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f, exists := s.flights[flightKey(flight, day)]
+	if !exists {
+		return Flight{}, nil, errFlightNotFound
+	}
+	return f, s.available(f), nil
+}
+
+func (s *memoryStore) Flights() ([]FlightResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]FlightResponse, 0, len(s.flights))
+	for _, f := range s.flights {
+		list = append(list, FlightResponse{Flight: f, Available: s.available(f)})
+	}
+	return list, nil
+}
+
+// available returns the free seats of each cabin. Callers hold s.mu.
+func (s *memoryStore) available(f Flight) map[string]int {
+	key := flightKey(f.Flight, f.Day)
+	available := make(map[string]int, len(f.Seats))
+	for cabin, seats := range f.Seats {
+		available[cabin] = max(seats-s.sold[key][cabin], 0)
+	}
+	return available
+}
+
+func (s *memoryStore) Sell(transaction Transaction) (Transaction, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := flightKey(transaction.Flight, transaction.Day)
+	flight, exists := s.flights[key]
+	if !exists {
+		return Transaction{}, false, errFlightNotFound
+	}
+	if id, exists := s.references[transaction.Reference]; transaction.Reference != "" && exists {
+		original := s.transactions[id]
+		if original.Status == statusHeld && !transaction.Date.Before(original.ExpiresAt) {
+			// The sweeper has not released the hold yet, but it no longer
+			// takes the seat.
+			released, err := s.release(original)
+			if err != nil {
+				return Transaction{}, false, err
+			}
+			original = released
+		}
+		duplicate, err := reuse(original, transaction)
+		if err != nil {
+			return Transaction{}, false, err
+		}
+		if duplicate {
+			return original, true, nil
+		}
+	}
+
+	seats, exists := flight.Seats[transaction.Cabin]
+	if !exists {
+		return Transaction{}, false, errUnknownCabin
+	}
+	if s.sold[key][transaction.Cabin] >= seats {
+		return Transaction{}, false, errSoldOut
+	}
+
+	if err := s.apply(transaction); err != nil {
+		return Transaction{}, false, err
+	}
+	return transaction, false, nil
+}
+
+// reuse decides what transaction does with the Reference of original. A
+// retry of the same seat gets original back while original still takes the
+// seat; once it was released, expired or cancelled, the retry takes a seat
+// again. A reference used for another seat, or a sale retried over a hold,
+// conflicts.
+func reuse(original, transaction Transaction) (duplicate bool, err error) {
+	switch {
+	case original.Flight != transaction.Flight || original.Day != transaction.Day || original.Cabin != transaction.Cabin:
+		return false, errReferenceConflict
+	case !takesSeat(original.Status):
+		return false, nil
+	case original.Status == transaction.Status,
+		transaction.Status == statusHeld && original.Status == statusSold:
+		return true, nil
+	default:
+		return false, errReferenceConflict
+	}
+}
+
+func (s *memoryStore) Cancel(id string) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction, exists := s.transactions[id]
+	if !exists {
+		return Transaction{}, errTransactionNotFound
+	}
+	switch transaction.Status {
+	case statusHeld:
+		return s.release(transaction)
+	case statusSold:
+		transaction.Status = statusCancelled
+		transaction.CancelledAt = time.Now()
+		if err := s.apply(transaction); err != nil {
+			return Transaction{}, err
+		}
+	}
+	return transaction, nil
+}
+
+func (s *memoryStore) Confirm(id string, now time.Time) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction, exists := s.transactions[id]
+	if !exists {
+		return Transaction{}, errTransactionNotFound
+	}
+	switch transaction.Status {
+	case statusSold:
+		return transaction, nil
+	case statusHeld:
+	default:
+		return Transaction{}, errHoldNotActive
+	}
+
+	if !now.Before(transaction.ExpiresAt) {
+		if _, err := s.release(transaction); err != nil {
+			return Transaction{}, err
+		}
+		return Transaction{}, errHoldNotActive
+	}
+
+	transaction.Status = statusSold
+	transaction.ExpiresAt = time.Time{}
+	if err := s.apply(transaction); err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+func (s *memoryStore) Release(id string) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction, exists := s.transactions[id]
+	if !exists {
+		return Transaction{}, errTransactionNotFound
+	}
+	switch transaction.Status {
+	case statusHeld:
+		return s.release(transaction)
+	case statusSold:
+		return Transaction{}, errHoldConfirmed
+	}
+	return transaction, nil
+}
+
+func (s *memoryStore) ReleaseExpired(now time.Time) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var released []Transaction
+	for id := range s.holds {
+		if now.Before(s.transactions[id].ExpiresAt) {
+			continue
+		}
+		transaction, err := s.release(s.transactions[id])
+		if err != nil {
+			return released, err
+		}
+		released = append(released, transaction)
+	}
+	return released, nil
+}
+
+// release releases a hold. Callers hold s.mu.
+func (s *memoryStore) release(transaction Transaction) (Transaction, error) {
+	transaction.Status = statusReleased
+	transaction.ReleasedAt = time.Now()
+	if err := s.apply(transaction); err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+func (s *memoryStore) Transactions() ([]Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.snapshot(), nil
+}
+
+// snapshot is the last state of every transaction, in the order they were
+// created. Callers hold s.mu.
+func (s *memoryStore) snapshot() []Transaction {
+	list := make([]Transaction, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.transactions[id])
+	}
+	return list
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// apply persists and stores transaction. Callers hold s.mu.
+func (s *memoryStore) apply(transaction Transaction) error {
+	if s.persist != nil {
+		if err := s.persist(transaction); err != nil {
+			return err
+		}
+	}
+	s.load(transaction)
+	return nil
+}
+
+func (s *memoryStore) load(transaction Transaction) {
+	// Sales recorded before cabins existed were all economy.
+	transaction.Cabin = cmp.Or(transaction.Cabin, defaultCabin)
+
+	previous, exists := s.transactions[transaction.ID]
+	if !exists {
+		s.order = append(s.order, transaction.ID)
+	} else if takesSeat(previous.Status) {
+		s.countSeat(previous, -1)
+	}
+	if takesSeat(transaction.Status) {
+		s.countSeat(transaction, 1)
+	}
+	if transaction.Status == statusHeld {
+		s.holds[transaction.ID] = true
+	} else {
+		delete(s.holds, transaction.ID)
+	}
+	s.transactions[transaction.ID] = transaction
+	if transaction.Reference != "" {
+		s.references[transaction.Reference] = transaction.ID
+	}
+}
+
+func takesSeat(status string) bool {
+	return status == statusSold || status == statusHeld
+}
+
+func (s *memoryStore) countSeat(transaction Transaction, delta int) {
+	key := flightKey(transaction.Flight, transaction.Day)
+	if s.sold[key] == nil {
+		s.sold[key] = make(map[string]int)
+	}
+	s.sold[key][transaction.Cabin] += delta
+}
+
+// fileStore keeps the sales in memory and in an append-only log with one
+// transaction per line. Replaying the log rebuilds the last state of each,
+// and the log is compacted to that state when it is opened and closed. A
+// corrupt line fails the replay, so the service does not start with sales
+// missing.
+type fileStore struct {
+	*memoryStore
+	log *platform.AppendLog[Transaction]
+}
+
+func openFileStore(path string, catalog []Flight) (*fileStore, error) {
+	s := &fileStore{memoryStore: newMemoryStore(catalog)}
+	if err := platform.ReplayAppendLog(path, s.load); err != nil {
+		return nil, err
+	}
+
+	log, err := platform.OpenAppendLog(path, s.snapshot())
+	if err != nil {
+		return nil, err
+	}
+	s.log = log
+	s.persist = log.Append
+
+	logger.Info("restored transactions", "transactions", len(s.order), "path", path)
+	return s, nil
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.Close(s.snapshot())
+}
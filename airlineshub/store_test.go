@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testCatalog = []Flight{{
+	Flight:      "AA123",
+	Day:         "2025-11-15",
+	Airline:     "American Airlines",
+	Origin:      "JFK",
+	Destination: "GRU",
+	Departure:   time.Date(2025, 11, 15, 22, 30, 0, 0, time.FixedZone("", -5*3600)),
+	Arrival:     time.Date(2025, 11, 16, 9, 45, 0, 0, time.FixedZone("", -3*3600)),
+	Value:       500,
+	Seats:       map[string]int{"economy": 2, "business": 1},
+}}
+
+// storeFactories opens every Store implementation over testCatalog.
+var storeFactories = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return newMemoryStore(testCatalog)
+	},
+	"file": func(t *testing.T) Store {
+		s, err := openFileStore(filepath.Join(t.TempDir(), "transactions.log"), testCatalog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+}
+
+func sale(id, reference, cabin, status string, now time.Time) Transaction {
+	transaction := Transaction{
+		ID:        id,
+		Reference: reference,
+		Flight:    "AA123",
+		Day:       "2025-11-15",
+		Cabin:     cabin,
+		Date:      now,
+		Status:    status,
+	}
+	if status == statusHeld {
+		transaction.ExpiresAt = now.Add(time.Minute)
+	}
+	return transaction
+}
+
+func availableSeats(t *testing.T, s Store, cabin string) int {
+	t.Helper()
+
+	_, seats, err := s.Flight("AA123", "2025-11-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seats[cabin]
+}
+
+func TestStores(t *testing.T) {
+	for name, open := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			now := time.Now()
+
+			if _, _, err := s.Sell(sale("t1", "r1", "economy", statusSold, now)); err != nil {
+				t.Fatalf("sell: %v", err)
+			}
+			original, duplicate, err := s.Sell(sale("t1-retry", "r1", "economy", statusSold, now))
+			if err != nil || !duplicate || original.ID != "t1" {
+				t.Fatalf("repeated reference: got %s, duplicate %t, %v; want t1, true", original.ID, duplicate, err)
+			}
+			if _, _, err := s.Sell(sale("t1-other", "r1", "business", statusSold, now)); !errors.Is(err, errReferenceConflict) {
+				t.Fatalf("reference reused for another cabin: got %v, want %v", err, errReferenceConflict)
+			}
+			if _, _, err := s.Sell(sale("t1-hold", "r1", "economy", statusHeld, now)); err != nil {
+				t.Fatalf("hold retried after the sale: %v", err)
+			}
+			if _, _, err := s.Sell(sale("t2", "r2", "first", statusSold, now)); !errors.Is(err, errUnknownCabin) {
+				t.Fatalf("unknown cabin: got %v, want %v", err, errUnknownCabin)
+			}
+			if _, _, err := s.Sell(sale("t2", "r2", "economy", statusSold, now)); err != nil {
+				t.Fatalf("second seat: %v", err)
+			}
+			if _, _, err := s.Sell(sale("t3", "r3", "economy", statusSold, now)); !errors.Is(err, errSoldOut) {
+				t.Fatalf("third economy seat: got %v, want %v", err, errSoldOut)
+			}
+
+			// A hold takes the seat until it is released.
+			if _, _, err := s.Sell(sale("h1", "r4", "business", statusHeld, now)); err != nil {
+				t.Fatalf("hold: %v", err)
+			}
+			if got := availableSeats(t, s, "business"); got != 0 {
+				t.Fatalf("business seats while held: %d, want 0", got)
+			}
+			if _, err := s.Release("h1"); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+			if got := availableSeats(t, s, "business"); got != 1 {
+				t.Fatalf("business seats after release: %d, want 1", got)
+			}
+			if _, err := s.Confirm("h1", now); !errors.Is(err, errHoldNotActive) {
+				t.Fatalf("confirm released hold: got %v, want %v", err, errHoldNotActive)
+			}
+
+			// The reference of a released hold takes a seat again.
+			if retried, duplicate, err := s.Sell(sale("h1-retry", "r4", "business", statusHeld, now)); err != nil || duplicate || retried.ID != "h1-retry" {
+				t.Fatalf("reference of a released hold: got %s, duplicate %t, %v; want h1-retry, false", retried.ID, duplicate, err)
+			}
+			if _, err := s.Release("h1-retry"); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+
+			// A confirmed hold is a sale: it can no longer be released.
+			if _, _, err := s.Sell(sale("h2", "r5", "business", statusHeld, now)); err != nil {
+				t.Fatalf("hold: %v", err)
+			}
+			if _, _, err := s.Sell(sale("h2-sale", "r5", "business", statusSold, now)); !errors.Is(err, errReferenceConflict) {
+				t.Fatalf("sale over an unconfirmed hold: got %v, want %v", err, errReferenceConflict)
+			}
+			if confirmed, err := s.Confirm("h2", now); err != nil || confirmed.Status != statusSold {
+				t.Fatalf("confirm: got %s, %v; want %s", confirmed.Status, err, statusSold)
+			}
+			if _, err := s.Release("h2"); !errors.Is(err, errHoldConfirmed) {
+				t.Fatalf("release confirmed hold: got %v, want %v", err, errHoldConfirmed)
+			}
+
+			// Cancelling gives the seat back, and again changes nothing.
+			for range 2 {
+				cancelled, err := s.Cancel("t1")
+				if err != nil || cancelled.Status != statusCancelled {
+					t.Fatalf("cancel: got %s, %v; want %s", cancelled.Status, err, statusCancelled)
+				}
+			}
+			if got := availableSeats(t, s, "economy"); got != 1 {
+				t.Fatalf("economy seats after cancel: %d, want 1", got)
+			}
+			if again, duplicate, err := s.Sell(sale("t1-again", "r1", "economy", statusSold, now)); err != nil || duplicate || again.ID != "t1-again" {
+				t.Fatalf("reference of a cancelled sale: got %s, duplicate %t, %v; want t1-again, false", again.ID, duplicate, err)
+			}
+			if _, err := s.Cancel("t1-again"); err != nil {
+				t.Fatalf("cancel: %v", err)
+			}
+			if _, err := s.Cancel("missing"); !errors.Is(err, errTransactionNotFound) {
+				t.Fatalf("cancel unknown: got %v, want %v", err, errTransactionNotFound)
+			}
+
+			// Expired holds are released by the sweeper.
+			if _, _, err := s.Sell(sale("h3", "r6", "economy", statusHeld, now)); err != nil {
+				t.Fatalf("hold: %v", err)
+			}
+			released, err := s.ReleaseExpired(now.Add(2 * time.Minute))
+			if err != nil || len(released) != 1 || released[0].ID != "h3" {
+				t.Fatalf("release expired: got %v, %v; want h3", released, err)
+			}
+
+			// A hold past its expiry no longer takes the seat, even before
+			// the sweeper runs: its reference gets a fresh hold.
+			if _, _, err := s.Sell(sale("h4", "r7", "economy", statusHeld, now)); err != nil {
+				t.Fatalf("hold: %v", err)
+			}
+			later := now.Add(2 * time.Minute)
+			if retried, duplicate, err := s.Sell(sale("h4-retry", "r7", "economy", statusHeld, later)); err != nil || duplicate || retried.ID != "h4-retry" {
+				t.Fatalf("reference of an expired hold: got %s, duplicate %t, %v; want h4-retry, false", retried.ID, duplicate, err)
+			}
+			if got := availableSeats(t, s, "economy"); got != 0 {
+				t.Fatalf("economy seats after the expired hold was retried: %d, want 0", got)
+			}
+
+			transactions, err := s.Transactions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, transaction := range transactions {
+				ids = append(ids, transaction.ID)
+			}
+			if want := []string{"t1", "t2", "h1", "h1-retry", "h2", "t1-again", "h3", "h4", "h4-retry"}; !slices.Equal(ids, want) {
+				t.Fatalf("transactions: got %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestFileStoreRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	s, err := openFileStore(path, testCatalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.Sell(sale("t1", "r1", "economy", statusSold, now))
+	s.Sell(sale("t2", "r2", "economy", statusSold, now))
+	s.Cancel("t1")
+	s.Sell(sale("h1", "r3", "business", statusHeld, now))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openFileStore(path, testCatalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if got := availableSeats(t, s, "economy"); got != 1 {
+		t.Fatalf("economy seats after restart: %d, want 1", got)
+	}
+	if got := availableSeats(t, s, "business"); got != 0 {
+		t.Fatalf("business seats after restart: %d, want 0 (held)", got)
+	}
+	if original, duplicate, _ := s.Sell(sale("t4", "r2", "economy", statusSold, now)); !duplicate || original.ID != "t2" {
+		t.Fatalf("reference after restart: got %s, duplicate %t; want t2, true", original.ID, duplicate)
+	}
+	if _, err := s.Confirm("h1", now); err != nil {
+		t.Fatalf("confirm hold after restart: %v", err)
+	}
+
+	// The log was compacted to the last state of each transaction.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three compacted lines plus the confirmation appended since.
+	if lines := bytes.Count(data, []byte("\n")); lines != 4 {
+		t.Fatalf("log has %d lines after compaction, want 4", lines)
+	}
+}
+
+func TestFileStoreSkipsTruncatedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	s, err := openFileStore(path, testCatalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Sell(sale("t1", "r1", "economy", statusSold, time.Now()))
+	s.Close()
+
+	// A crash in the middle of a write leaves a partial line behind.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"id":"t2","flight":"AA1`)
+	file.Close()
+
+	s, err = openFileStore(path, testCatalog)
+	if err != nil {
+		t.Fatalf("reopen with a truncated line: %v", err)
+	}
+	defer s.Close()
+
+	transactions, _ := s.Transactions()
+	if len(transactions) != 1 || transactions[0].ID != "t1" {
+		t.Fatalf("transactions: got %v, want only t1", transactions)
+	}
+	if got := availableSeats(t, s, "economy"); got != 1 {
+		t.Fatalf("economy seats: %d, want 1", got)
+	}
+
+	// The partial line is gone, so the next append starts a line of its own.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"t2"`)) || !bytes.HasSuffix(data, []byte("\n")) {
+		t.Fatalf("log still holds the truncated line: %q", data)
+	}
+}
+
+func TestFileStoreRejectsCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.log")
+	s, err := openFileStore(path, testCatalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Sell(sale("t1", "r1", "economy", statusSold, time.Now()))
+	s.Close()
+
+	// A complete line that does not parse is not a torn write: dropping it
+	// would lose a sale, and everything after it would replay on top.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append([]byte(`{"id":"t0","flight":"AA1`+"\n"), data...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err := openFileStore(path, testCatalog); err == nil {
+		s.Close()
+		t.Fatal("reopen with a corrupt line in the middle: got nil error")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+		t.Fatal("a failed replay rewrote the log")
+	}
+}
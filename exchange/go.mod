@@ -1,3 +1,7 @@
 module exchange
 
-go 1.25
\ No newline at end of file
+go 1.25
+
+require platform v0.0.0
+
+replace platform => ../platform
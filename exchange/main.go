@@ -1,27 +1,61 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"flag"
 	"math/rand/v2"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"platform"
 )
 
 var (
-	faultR2Mutex   sync.Mutex
-	faultR2Active  bool
-	faultR2EndTime time.Time
+	logger = platform.Init("exchange")
+
+	faults = platform.NewFaultInjector(
+		platform.Fault{Name: "convert_error", Endpoint: "/convert", Type: platform.FaultError, Enabled: true, Probability: 0.1,
+			Duration: 5 * time.Second, Status: http.StatusInternalServerError},
+	)
 )
 
 func main() {
-	http.HandleFunc("/convert", getExchangeRateHandler)
-	http.HandleFunc("/health", healthHandler)
+	healthcheck := flag.Bool("healthcheck", false, "check /livez of the running service and exit")
+	flag.Parse()
 
 	port := ":8082"
-	log.Printf("Exchange service starting on port %s", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	if *healthcheck {
+		os.Exit(platform.RunHealthcheck(port))
+	}
+
+	http.HandleFunc("/convert", platform.Instrument("/convert", platform.Traced(getExchangeRateHandler)))
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/livez", platform.LivezHandler)
+	http.HandleFunc("/readyz", platform.ReadyzHandler)
+	http.HandleFunc("/metrics", platform.MetricsHandler)
+	faults.HandleFaults()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	platform.StartTracing()
+	platform.Ready.Store(true)
+
+	logger.Info("service starting", "port", port)
+	err := platform.Serve(ctx, port)
+	if err != nil {
+		logger.Error("server stopped", "error", err)
+	}
+
+	platform.StopTracing()
+	logger.Info("service stopped")
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -35,39 +69,17 @@ func getExchangeRateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const (
-		probR2     = 0.1
-		durationR2 = 5 * time.Second
-	)
-
-	faultR2Mutex.Lock()
-	now := time.Now()
-
-	if faultR2Active && now.Before(faultR2EndTime) {
-		log.Println("[FAULT] Request 2: Error STATE active. Returning HTTP 500.")
-		faultR2Mutex.Unlock()
-		http.Error(w, "Internal Server Error (Simulated Fault State)", http.StatusInternalServerError)
+	if fault, ok := faults.Trigger("convert_error"); ok {
+		logger.WarnContext(r.Context(), "simulated fault: returning an error", "fault", fault.Type, "status", fault.Status)
+		platform.AddSpanEvent(r.Context(), "fault", map[string]string{"type": fault.Type})
+		http.Error(w, "Internal Server Error (Simulated Fault State)", fault.Status)
 		return
-
-	} else {
-		faultR2Active = false
-
-		if rand.Float64() < probR2 {
-			log.Println("[FAULT] Request 2: Error fault TRIGGERED. State active for 5s.")
-			faultR2Active = true
-			faultR2EndTime = now.Add(durationR2)
-
-			faultR2Mutex.Unlock()
-			http.Error(w, "Internal Server Error (Simulated Fault State)", http.StatusInternalServerError)
-			return
-		}
 	}
-	faultR2Mutex.Unlock()
 
 	intValue := 5000 + rand.IntN(1001)
 	exchangeRate := float64(intValue) / 1000.0
 
-	log.Printf("Exchange rate generated: %.4f", exchangeRate)
+	logger.InfoContext(r.Context(), "exchange rate generated", "rate", exchangeRate)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -1,24 +1,37 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
-	"math/rand/v2"
+	"flag"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"sync"
+	"syscall"
 	"time"
+
+	"platform"
 )
 
 type BonusRequest struct {
-	User  string `json:"user"`
-	Bonus int    `json:"bonus"`
+	User          string `json:"user"`
+	Bonus         int    `json:"bonus"`
+	TransactionID string `json:"transaction_id,omitempty"`
+}
+
+type ReverseRequest struct {
+	TransactionID string `json:"transaction_id"`
 }
 
+// BonusRecord is an entry of a user's ledger. A reversal is recorded as a
+// negative Bonus with the TransactionID of the bonus it undoes.
 type BonusRecord struct {
-	User      string
-	Bonus     int
-	Timestamp time.Time
+	User          string
+	Bonus         int
+	TransactionID string
+	Timestamp     time.Time
 }
 
 type UserPoints struct {
@@ -28,18 +41,57 @@ type UserPoints struct {
 }
 
 var (
-	userPoints = make(map[string]*UserPoints)
-	mu         sync.RWMutex
+	logger = platform.Init("fidelity")
+
+	// The ledger and the results kept to answer repeated transactions live
+	// in memory only. A crash drops them together, so a bonus sent again
+	// after a restart is credited once on the new ledger.
+	userPoints       = make(map[string]*UserPoints)
+	processedBonuses = make(map[string]map[string]interface{})
+	reversedBonuses  = make(map[string]map[string]interface{})
+	mu               sync.RWMutex
+
+	faults = platform.NewFaultInjector(
+		platform.Fault{Name: "bonus_crash", Endpoint: "/bonus", Type: platform.FaultCrash, Enabled: true, Probability: 0.02},
+	)
 )
 
 func main() {
-	http.HandleFunc("/bonus", registerBonusHandler)
-	http.HandleFunc("/points", getPointsHandler)
-	http.HandleFunc("/health", healthHandler)
+	healthcheck := flag.Bool("healthcheck", false, "check /livez of the running service and exit")
+	flag.Parse()
 
 	port := ":8083"
-	log.Printf("Fidelity service starting on port %s", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	if *healthcheck {
+		os.Exit(platform.RunHealthcheck(port))
+	}
+
+	http.HandleFunc("/bonus", platform.Instrument("/bonus", platform.Traced(registerBonusHandler)))
+	http.HandleFunc("/bonus/reverse", platform.Instrument("/bonus/reverse", platform.Traced(reverseBonusHandler)))
+	http.HandleFunc("/points", platform.Instrument("/points", platform.Traced(getPointsHandler)))
+	http.HandleFunc("/bonuses", platform.Instrument("/bonuses", platform.Traced(platform.AdminOnly(listBonusesHandler))))
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/livez", platform.LivezHandler)
+	http.HandleFunc("/readyz", platform.ReadyzHandler)
+	http.HandleFunc("/metrics", platform.MetricsHandler)
+	faults.HandleFaults()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	platform.StartTracing()
+	platform.Ready.Store(true)
+
+	logger.Info("service starting", "port", port)
+	err := platform.Serve(ctx, port)
+	if err != nil {
+		logger.Error("server stopped", "error", err)
+	}
+
+	platform.StopTracing()
+	logger.Info("service stopped")
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -53,8 +105,8 @@ func registerBonusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if rand.Float64() < 0.02 {
-		log.Println("[FAULT] Request 4: Crash fault triggered - Service shutting down")
+	if fault, ok := faults.Trigger("bonus_crash"); ok {
+		logger.ErrorContext(r.Context(), "simulated fault: shutting down", "fault", fault.Type)
 		os.Exit(1)
 	}
 
@@ -75,12 +127,29 @@ func registerBonusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	record := BonusRecord{
-		User:      req.User,
-		Bonus:     req.Bonus,
-		Timestamp: time.Now(),
+		User:          req.User,
+		Bonus:         req.Bonus,
+		TransactionID: req.TransactionID,
+		Timestamp:     time.Now(),
 	}
 
 	mu.Lock()
+	if original, exists := processedBonuses[req.TransactionID]; req.TransactionID != "" && exists {
+		mu.Unlock()
+		if original["user"] != req.User || original["bonus_added"] != req.Bonus {
+			logger.InfoContext(r.Context(), "bonus rejected, transaction already credited with another user or bonus",
+				"user", req.User, "bonus", req.Bonus, "transaction_id", req.TransactionID)
+			respondError(w, "Transaction already credited with a different user or bonus", http.StatusUnprocessableEntity)
+			return
+		}
+		logger.InfoContext(r.Context(), "duplicate bonus, returning original result",
+			"user", req.User, "transaction_id", req.TransactionID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(original)
+		return
+	}
+
 	if userPoints[req.User] == nil {
 		userPoints[req.User] = &UserPoints{
 			User:        req.User,
@@ -90,10 +159,6 @@ func registerBonusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	userPoints[req.User].TotalPoints += req.Bonus
 	userPoints[req.User].Records = append(userPoints[req.User].Records, record)
-	mu.Unlock()
-
-	log.Printf("Bonus registered: user=%s, bonus=%d, total=%d",
-		req.User, req.Bonus, userPoints[req.User].TotalPoints)
 
 	response := map[string]interface{}{
 		"success":      true,
@@ -101,6 +166,78 @@ func registerBonusHandler(w http.ResponseWriter, r *http.Request) {
 		"bonus_added":  req.Bonus,
 		"total_points": userPoints[req.User].TotalPoints,
 	}
+	if req.TransactionID != "" {
+		response["transaction_id"] = req.TransactionID
+		processedBonuses[req.TransactionID] = response
+	}
+	mu.Unlock()
+
+	logger.InfoContext(r.Context(), "bonus registered",
+		"user", req.User, "bonus", req.Bonus, "transaction_id", req.TransactionID, "total_points", response["total_points"])
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// reverseBonusHandler undoes the bonus of a transaction by adding a negative
+// entry to the user's ledger. Reversing it again returns the first result.
+func reverseBonusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReverseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.TransactionID == "" {
+		respondError(w, "Missing required field: transaction_id", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	if original, exists := reversedBonuses[req.TransactionID]; exists {
+		mu.Unlock()
+		logger.InfoContext(r.Context(), "duplicate reversal, returning original result", "transaction_id", req.TransactionID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(original)
+		return
+	}
+
+	original, exists := processedBonuses[req.TransactionID]
+	if !exists {
+		mu.Unlock()
+		respondError(w, "Bonus not found", http.StatusNotFound)
+		return
+	}
+
+	user := original["user"].(string)
+	bonus := original["bonus_added"].(int)
+	userPoints[user].TotalPoints -= bonus
+	userPoints[user].Records = append(userPoints[user].Records, BonusRecord{
+		User:          user,
+		Bonus:         -bonus,
+		TransactionID: req.TransactionID,
+		Timestamp:     time.Now(),
+	})
+
+	response := map[string]interface{}{
+		"success":        true,
+		"user":           user,
+		"bonus_reversed": bonus,
+		"total_points":   userPoints[user].TotalPoints,
+		"transaction_id": req.TransactionID,
+	}
+	reversedBonuses[req.TransactionID] = response
+	mu.Unlock()
+
+	logger.InfoContext(r.Context(), "bonus reversed",
+		"user", user, "bonus", bonus, "transaction_id", req.TransactionID, "total_points", response["total_points"])
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -140,6 +277,36 @@ func getPointsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(points)
 }
 
+// listBonusesHandler lists every bonus record, oldest first, optionally only
+// those of one transaction. It is only open to the admin token.
+func listBonusesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transactionID := r.URL.Query().Get("transaction_id")
+
+	mu.RLock()
+	records := make([]BonusRecord, 0)
+	for _, points := range userPoints {
+		for _, record := range points.Records {
+			if transactionID == "" || record.TransactionID == transactionID {
+				records = append(records, record)
+			}
+		}
+	}
+	mu.RUnlock()
+
+	slices.SortFunc(records, func(a, b BonusRecord) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(records)
+}
+
 func respondError(w http.ResponseWriter, message string, statusCode int) {
 	response := map[string]string{
 		"error": message,
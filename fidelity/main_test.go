@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// useEmptyLedger starts the test with no points and with the crash fault
+// off, since it exits the process.
+func useEmptyLedger(t *testing.T) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	faults.FaultsHandler(recorder, httptest.NewRequest(http.MethodPut, "/faults?name=bonus_crash", strings.NewReader(`{"enabled":false}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("disabling bonus_crash: status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	resetLedger()
+}
+
+// resetLedger drops the ledger and its deduplication, as a crash does.
+func resetLedger() {
+	mu.Lock()
+	defer mu.Unlock()
+	userPoints = make(map[string]*UserPoints)
+	processedBonuses = make(map[string]map[string]interface{})
+	reversedBonuses = make(map[string]map[string]interface{})
+}
+
+// ledgerStep is a request to the ledger and the total_points it must
+// answer. A repeated request answers the total of the original.
+type ledgerStep struct {
+	handler    http.HandlerFunc
+	body       string
+	wantStatus int
+	wantTotal  float64
+}
+
+// restart is a step that restarts the service: the ledger starts over.
+var restart = ledgerStep{}
+
+func TestBonusLedger(t *testing.T) {
+	const bonus, reversal = `{"user":"u1","bonus":500,"transaction_id":"tx-1"}`, `{"transaction_id":"tx-1"}`
+	register := func(body string, total float64) ledgerStep {
+		return ledgerStep{registerBonusHandler, body, http.StatusOK, total}
+	}
+	reverse := func(body string, total float64) ledgerStep {
+		return ledgerStep{reverseBonusHandler, body, http.StatusOK, total}
+	}
+
+	tests := []struct {
+		name        string
+		steps       []ledgerStep
+		wantPoints  int
+		wantRecords []int
+	}{
+		{
+			name:        "bonus registered",
+			steps:       []ledgerStep{register(bonus, 500)},
+			wantPoints:  500,
+			wantRecords: []int{500},
+		},
+		{
+			name: "repeated transaction credited once",
+			steps: []ledgerStep{
+				register(bonus, 500),
+				register(bonus, 500),
+			},
+			wantPoints:  500,
+			wantRecords: []int{500},
+		},
+		{
+			name: "transaction repeated with another user or bonus",
+			steps: []ledgerStep{
+				register(bonus, 500),
+				{handler: registerBonusHandler, body: `{"user":"u1","bonus":900,"transaction_id":"tx-1"}`, wantStatus: http.StatusUnprocessableEntity},
+				{handler: registerBonusHandler, body: `{"user":"u2","bonus":500,"transaction_id":"tx-1"}`, wantStatus: http.StatusUnprocessableEntity},
+			},
+			wantPoints:  500,
+			wantRecords: []int{500},
+		},
+		{
+			name: "bonuses without transaction all credited",
+			steps: []ledgerStep{
+				register(`{"user":"u1","bonus":500}`, 500),
+				register(`{"user":"u1","bonus":500}`, 1000),
+			},
+			wantPoints:  1000,
+			wantRecords: []int{500, 500},
+		},
+		{
+			name:        "reversal applied once",
+			steps:       []ledgerStep{register(bonus, 500), reverse(reversal, 0), reverse(reversal, 0)},
+			wantPoints:  0,
+			wantRecords: []int{500, -500},
+		},
+		{
+			name:        "registration repeated after its reversal",
+			steps:       []ledgerStep{register(bonus, 500), reverse(reversal, 0), register(bonus, 500)},
+			wantPoints:  0,
+			wantRecords: []int{500, -500},
+		},
+		{
+			// Deduplication lives as long as the process, like the ledger.
+			name:        "repeated transaction after a restart credited once",
+			steps:       []ledgerStep{register(bonus, 500), restart, register(bonus, 500), register(bonus, 500)},
+			wantPoints:  500,
+			wantRecords: []int{500},
+		},
+		{
+			name: "reversal of an unknown transaction",
+			steps: []ledgerStep{
+				register(bonus, 500),
+				{handler: reverseBonusHandler, body: `{"transaction_id":"tx-2"}`, wantStatus: http.StatusNotFound},
+			},
+			wantPoints:  500,
+			wantRecords: []int{500},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useEmptyLedger(t)
+
+			for i, step := range tt.steps {
+				if step.handler == nil {
+					resetLedger()
+					continue
+				}
+				recorder := httptest.NewRecorder()
+				step.handler(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(step.body)))
+				if recorder.Code != step.wantStatus {
+					t.Fatalf("step %d: status %d, want %d: %s", i+1, recorder.Code, step.wantStatus, recorder.Body)
+				}
+				if step.wantStatus != http.StatusOK {
+					continue
+				}
+
+				var response struct {
+					TotalPoints float64 `json:"total_points"`
+				}
+				if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+					t.Fatal(err)
+				}
+				if response.TotalPoints != step.wantTotal {
+					t.Fatalf("step %d: answered %v points, want %v", i+1, response.TotalPoints, step.wantTotal)
+				}
+			}
+
+			mu.RLock()
+			defer mu.RUnlock()
+			points := userPoints["u1"]
+			if points.TotalPoints != tt.wantPoints {
+				t.Fatalf("total %d points, want %d", points.TotalPoints, tt.wantPoints)
+			}
+			var records []int
+			for _, record := range points.Records {
+				records = append(records, record.Bonus)
+			}
+			if !slices.Equal(records, tt.wantRecords) {
+				t.Fatalf("ledger %v, want %v", records, tt.wantRecords)
+			}
+		})
+	}
+}
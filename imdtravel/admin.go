@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"platform"
+)
+
+type AdminActionResult struct {
+	Key     string `json:"key"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// registerAdminHandlers registers the support endpoints. All of them need the
+// admin token: the queues carry users and request IDs, and the audit reads
+// every purchase.
+func registerAdminHandlers() {
+	http.HandleFunc("/admin/pending", platform.AdminOnly(pendingBonusesHandler))
+	http.HandleFunc("/admin/pending/retry", platform.AdminOnly(retryPendingBonusesHandler))
+	http.HandleFunc("/admin/dead-letters", platform.AdminOnly(deadLettersHandler))
+	http.HandleFunc("/admin/dead-letters/requeue", platform.AdminOnly(requeueDeadLettersHandler))
+	http.HandleFunc("/admin/audit", platform.AdminOnly(auditHandler))
+}
+
+func pendingBonusesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, listPendingBonuses(), http.StatusOK)
+	case http.MethodDelete:
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			respondError(w, "Missing required parameter: key", http.StatusBadRequest)
+			return
+		}
+		respondAdminActions(w, []string{key}, dropPendingBonus)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func retryPendingBonusesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys := pendingBonusKeys()
+	if key := r.URL.Query().Get("key"); key != "" {
+		keys = []string{key}
+	}
+	respondAdminActions(w, keys, retryPendingBonus)
+}
+
+func deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, listDeadLetters(), http.StatusOK)
+}
+
+func requeueDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys := deadLetterKeys()
+	if key := r.URL.Query().Get("key"); key != "" {
+		keys = []string{key}
+	}
+	respondAdminActions(w, keys, requeueDeadLetter)
+}
+
+// respondAdminActions runs action for every key and reports each outcome.
+// A request naming a single unknown key gets a 404.
+func respondAdminActions(w http.ResponseWriter, keys []string, action func(string) error) {
+	results := make([]AdminActionResult, 0, len(keys))
+	for _, key := range keys {
+		result := AdminActionResult{Key: key, Success: true}
+		if err := action(key); err != nil {
+			if len(keys) == 1 && errors.Is(err, errQueueEntryNotFound) {
+				respondError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			result.Success = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	respondJSON(w, results, http.StatusOK)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The audit reconciles AirlinesHub's sales, Fidelity's bonus records and the
+// purchase log. Sales newer than the purchase budget may belong to purchases
+// still running and are only counted.
+
+const (
+	issueOrphanSale      = "orphan_sale"
+	issueMissingSale     = "missing_sale"
+	issueMissingBonus    = "missing_bonus"
+	issueDuplicateBonus  = "duplicate_bonus"
+	issueUnexpectedBonus = "unexpected_bonus"
+	issuePendingBonus    = "pending_bonus"
+	issueDeadLetter      = "dead_letter"
+)
+
+const (
+	saleSold          = "sold"
+	auditFetchTimeout = 10 * time.Second
+)
+
+type saleRecord struct {
+	ID        string    `json:"id"`
+	Reference string    `json:"reference"`
+	Flight    string    `json:"flight"`
+	Day       string    `json:"day"`
+	Date      time.Time `json:"date"`
+	Status    string    `json:"status"`
+}
+
+// bonusRecord matches Fidelity's records, which are encoded without tags.
+type bonusRecord struct {
+	User          string
+	Bonus         int
+	TransactionID string
+	Timestamp     time.Time
+}
+
+type AuditIssue struct {
+	Kind          string        `json:"kind"`
+	TransactionID string        `json:"transaction_id,omitempty"`
+	SagaID        string        `json:"saga_id,omitempty"`
+	User          string        `json:"user,omitempty"`
+	Flight        string        `json:"flight,omitempty"`
+	Day           string        `json:"day,omitempty"`
+	Detail        string        `json:"detail"`
+	Repair        *RepairAction `json:"repair,omitempty"`
+}
+
+// RepairAction is the request that fixes an issue. Manual actions have no
+// method and describe what support has to do instead.
+type RepairAction struct {
+	Service     string `json:"service"`
+	Method      string `json:"method,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Body        any    `json:"body,omitempty"`
+	Description string `json:"description"`
+}
+
+type AuditReport struct {
+	CheckedAt time.Time      `json:"checked_at"`
+	Sales     int            `json:"sales"`
+	Purchases int            `json:"purchases"`
+	Bonuses   int            `json:"bonuses"`
+	InFlight  int            `json:"in_flight"`
+	Summary   map[string]int `json:"summary"`
+	Issues    []AuditIssue   `json:"issues"`
+}
+
+// auditHandler reports the inconsistencies between the three services.
+// With ?repair=true every issue carries the action that fixes it.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	repair, _ := strconv.ParseBool(r.URL.Query().Get("repair"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), auditFetchTimeout)
+	defer cancel()
+
+	var sales []saleRecord
+	if err := fetchJSON(ctx, airlinesHubURL+"/transactions", &sales); err != nil {
+		respondError(w, fmt.Sprintf("Failed to list AirlinesHub transactions: %v", err), http.StatusBadGateway)
+		return
+	}
+	var bonuses []bonusRecord
+	if err := fetchJSON(ctx, fidelityURL+"/bonuses", &bonuses); err != nil {
+		respondError(w, fmt.Sprintf("Failed to list Fidelity bonuses: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	report := runAudit(time.Now(), sales, bonuses, purchases.list(), listPendingBonuses(), listDeadLetters())
+	if !repair {
+		for i := range report.Issues {
+			report.Issues[i].Repair = nil
+		}
+	}
+
+	logger.InfoContext(r.Context(), "consistency audit finished",
+		"sales", report.Sales, "purchases", report.Purchases, "bonuses", report.Bonuses, "issues", len(report.Issues))
+	respondJSON(w, report, http.StatusOK)
+}
+
+func runAudit(now time.Time, sales []saleRecord, bonuses []bonusRecord, records []PurchaseRecord, pending, dead []QueueEntry) AuditReport {
+	report := AuditReport{
+		CheckedAt: now,
+		Sales:     len(sales),
+		Purchases: len(records),
+		Bonuses:   len(bonuses),
+		Summary:   make(map[string]int),
+		Issues:    []AuditIssue{},
+	}
+	add := func(issue AuditIssue) {
+		report.Summary[issue.Kind]++
+		report.Issues = append(report.Issues, issue)
+	}
+
+	salesByID := make(map[string]saleRecord, len(sales))
+	for _, sale := range sales {
+		salesByID[sale.ID] = sale
+	}
+	// Sales carry the reference of the purchase that made them: the saga ID,
+	// or one derived from the idempotency key, shared by its retries.
+	byReference := make(map[string]PurchaseRecord, len(records))
+	completed := make(map[string]PurchaseRecord)
+	for _, record := range records {
+		byReference[cmp.Or(record.Reference, record.SagaID)] = record
+		if record.Success {
+			completed[record.TransactionID] = record
+		}
+	}
+	// Reversals are negative records; unreversed counts the bonuses of a
+	// transaction that still add points.
+	bonusesByTx := make(map[string][]bonusRecord)
+	reversedByTx := make(map[string]int)
+	for _, bonus := range bonuses {
+		switch {
+		case bonus.TransactionID == "":
+		case bonus.Bonus < 0:
+			reversedByTx[bonus.TransactionID]++
+		default:
+			bonusesByTx[bonus.TransactionID] = append(bonusesByTx[bonus.TransactionID], bonus)
+		}
+	}
+	unreversed := func(transactionID string) int {
+		return len(bonusesByTx[transactionID]) - reversedByTx[transactionID]
+	}
+	pendingByTx := queueKeysByTransaction(pending)
+	deadByTx := queueKeysByTransaction(dead)
+
+	cutoff := now.Add(-purchaseBudget)
+	for _, sale := range sales {
+		if _, ok := completed[sale.ID]; ok {
+			continue
+		}
+		issue := AuditIssue{TransactionID: sale.ID, Flight: sale.Flight, Day: sale.Day}
+		if record, ok := byReference[sale.Reference]; ok {
+			issue.SagaID = record.SagaID
+			issue.User = record.User
+		}
+
+		switch {
+		case sale.Status == saleSold && sale.Date.After(cutoff):
+			report.InFlight++
+		case sale.Status == saleSold:
+			issue.Kind = issueOrphanSale
+			issue.Detail = "ticket sold but no purchase was confirmed to the customer"
+			if record, ok := byReference[sale.Reference]; ok {
+				issue.Detail = "ticket sold but the purchase failed: " + record.Error
+			}
+			issue.Repair = &RepairAction{
+				Service:     "airlineshub",
+				Method:      http.MethodPost,
+				Path:        "/cancel",
+				Body:        CancelRequest{ID: sale.ID},
+				Description: "cancel the sale",
+			}
+			add(issue)
+		case unreversed(sale.ID) > 0:
+			issue.Kind = issueUnexpectedBonus
+			issue.Detail = "bonus registered for a cancelled sale"
+			issue.Repair = reverseBonusRepair(sale.ID)
+			add(issue)
+		}
+	}
+
+	for _, record := range completed {
+		issue := AuditIssue{
+			TransactionID: record.TransactionID,
+			SagaID:        record.SagaID,
+			User:          record.User,
+			Flight:        record.Flight,
+			Day:           record.Day,
+		}
+
+		if record.Cancellation != nil {
+			if unreversed(record.TransactionID) > 0 {
+				issue.Kind = issueUnexpectedBonus
+				issue.Detail = "purchase cancelled by the customer but the bonus was not reversed"
+				issue.Repair = reverseBonusRepair(record.TransactionID)
+				add(issue)
+			}
+			continue
+		}
+
+		sale, sold := salesByID[record.TransactionID]
+		if !sold || sale.Status != saleSold {
+			issue.Kind = issueMissingSale
+			issue.Detail = "purchase confirmed to the customer but the sale is missing or cancelled"
+			issue.Repair = manualRepair("airlineshub", "sell the ticket again or refund the customer")
+			add(issue)
+			continue
+		}
+
+		switch registered := len(bonusesByTx[record.TransactionID]); {
+		case registered > 1:
+			issue.Kind = issueDuplicateBonus
+			issue.Detail = fmt.Sprintf("%d bonus records for the transaction", registered)
+			issue.Repair = manualRepair("fidelity", "remove the extra bonus points from the user")
+			add(issue)
+		case registered == 1:
+		case pendingByTx[record.TransactionID] != "":
+			key := pendingByTx[record.TransactionID]
+			issue.Kind = issuePendingBonus
+			issue.Detail = "bonus waiting in the pending queue"
+			issue.Repair = &RepairAction{
+				Service:     "imdtravel",
+				Method:      http.MethodPost,
+				Path:        "/admin/pending/retry?key=" + url.QueryEscape(key),
+				Description: "retry the pending bonus now",
+			}
+			add(issue)
+		case deadByTx[record.TransactionID] != "":
+			key := deadByTx[record.TransactionID]
+			issue.Kind = issueDeadLetter
+			issue.Detail = "bonus gave up retrying and is in the dead letters"
+			issue.Repair = &RepairAction{
+				Service:     "imdtravel",
+				Method:      http.MethodPost,
+				Path:        "/admin/dead-letters/requeue?key=" + url.QueryEscape(key),
+				Description: "requeue the bonus",
+			}
+			add(issue)
+		default:
+			issue.Kind = issueMissingBonus
+			issue.Detail = "no bonus registered and none queued"
+			issue.Repair = &RepairAction{
+				Service:     "fidelity",
+				Method:      http.MethodPost,
+				Path:        "/bonus",
+				Body:        BonusRequest{User: record.User, Bonus: record.BonusPoints, TransactionID: record.TransactionID},
+				Description: "register the bonus",
+			}
+			add(issue)
+		}
+	}
+
+	for id, registered := range bonusesByTx {
+		if _, ok := salesByID[id]; ok || unreversed(id) <= 0 {
+			continue
+		}
+		add(AuditIssue{
+			Kind:          issueUnexpectedBonus,
+			TransactionID: id,
+			User:          registered[0].User,
+			Detail:        "bonus registered for an unknown transaction",
+			Repair:        reverseBonusRepair(id),
+		})
+	}
+
+	slices.SortFunc(report.Issues, func(a, b AuditIssue) int {
+		return cmp.Or(cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.TransactionID, b.TransactionID))
+	})
+	return report
+}
+
+func reverseBonusRepair(transactionID string) *RepairAction {
+	return &RepairAction{
+		Service:     "fidelity",
+		Method:      http.MethodPost,
+		Path:        "/bonus/reverse",
+		Body:        ReverseBonusRequest{TransactionID: transactionID},
+		Description: "reverse the bonus",
+	}
+}
+
+func manualRepair(service, description string) *RepairAction {
+	return &RepairAction{Service: service, Description: "manual: " + description}
+}
+
+func queueKeysByTransaction(entries []QueueEntry) map[string]string {
+	keys := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.TransactionID != "" {
+			keys[entry.TransactionID] = entry.Key
+		}
+	}
+	return keys
+}
+
+func fetchJSON(ctx context.Context, target string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRunAudit(t *testing.T) {
+	now := time.Date(2025, 11, 15, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour)
+	recent := now.Add(-purchaseBudget / 2)
+
+	sale := func(tx, status string, at time.Time) saleRecord {
+		return saleRecord{ID: tx, Reference: "saga-" + tx, Flight: "AA123", Day: "2025-11-15", Date: at, Status: status}
+	}
+	bonus := func(tx string, points int) bonusRecord {
+		return bonusRecord{User: "u1", Bonus: points, TransactionID: tx}
+	}
+	purchase := func(tx string) PurchaseRecord {
+		return PurchaseRecord{SagaID: "saga-" + tx, User: "u1", Flight: "AA123", Day: "2025-11-15",
+			Success: true, TransactionID: tx, BonusPoints: 500}
+	}
+	cancelled := func(tx string) PurchaseRecord {
+		record := purchase(tx)
+		record.Cancellation = &CancellationReceipt{TransactionID: tx}
+		return record
+	}
+	queued := func(tx string) QueueEntry {
+		return QueueEntry{Key: tx, PendingBonus: PendingBonus{User: "u1", Bonus: 500, TransactionID: tx}}
+	}
+
+	tests := []struct {
+		name         string
+		sales        []saleRecord
+		bonuses      []bonusRecord
+		records      []PurchaseRecord
+		pending      []QueueEntry
+		dead         []QueueEntry
+		want         []string
+		wantInFlight int
+	}{
+		{
+			name:    "consistent purchase",
+			sales:   []saleRecord{sale("tx1", saleSold, old)},
+			bonuses: []bonusRecord{bonus("tx1", 500)},
+			records: []PurchaseRecord{purchase("tx1")},
+		},
+		{
+			name:    "sold without bonus",
+			sales:   []saleRecord{sale("tx1", saleSold, old)},
+			records: []PurchaseRecord{purchase("tx1")},
+			want:    []string{issueMissingBonus + ":tx1"},
+		},
+		{
+			name:    "bonus still pending",
+			sales:   []saleRecord{sale("tx1", saleSold, old)},
+			records: []PurchaseRecord{purchase("tx1")},
+			pending: []QueueEntry{queued("tx1")},
+			want:    []string{issuePendingBonus + ":tx1"},
+		},
+		{
+			name:    "bonus dead-lettered",
+			sales:   []saleRecord{sale("tx1", saleSold, old)},
+			records: []PurchaseRecord{purchase("tx1")},
+			dead:    []QueueEntry{queued("tx1")},
+			want:    []string{issueDeadLetter + ":tx1"},
+		},
+		{
+			name:    "duplicate bonus",
+			sales:   []saleRecord{sale("tx1", saleSold, old)},
+			bonuses: []bonusRecord{bonus("tx1", 500), bonus("tx1", 500)},
+			records: []PurchaseRecord{purchase("tx1")},
+			want:    []string{issueDuplicateBonus + ":tx1"},
+		},
+		{
+			name:    "confirmed without sale",
+			records: []PurchaseRecord{purchase("tx1")},
+			want:    []string{issueMissingSale + ":tx1"},
+		},
+		{
+			name:    "bonus without sale",
+			bonuses: []bonusRecord{bonus("tx9", 500)},
+			want:    []string{issueUnexpectedBonus + ":tx9"},
+		},
+		{
+			name:    "bonus without sale reversed",
+			bonuses: []bonusRecord{bonus("tx9", 500), bonus("tx9", -500)},
+		},
+		{
+			name:    "compensated sale keeping its bonus",
+			sales:   []saleRecord{sale("tx1", "cancelled", old)},
+			bonuses: []bonusRecord{bonus("tx1", 500)},
+			want:    []string{issueUnexpectedBonus + ":tx1"},
+		},
+		{
+			name:    "compensated sale with bonus reversed",
+			sales:   []saleRecord{sale("tx1", "cancelled", old)},
+			bonuses: []bonusRecord{bonus("tx1", 500), bonus("tx1", -500)},
+		},
+		{
+			name:    "cancelled purchase keeping its bonus",
+			sales:   []saleRecord{sale("tx1", "cancelled", old)},
+			bonuses: []bonusRecord{bonus("tx1", 500)},
+			records: []PurchaseRecord{cancelled("tx1")},
+			want:    []string{issueUnexpectedBonus + ":tx1"},
+		},
+		{
+			name:    "cancelled purchase with bonus reversed",
+			sales:   []saleRecord{sale("tx1", "cancelled", old)},
+			bonuses: []bonusRecord{bonus("tx1", 500), bonus("tx1", -500)},
+			records: []PurchaseRecord{cancelled("tx1")},
+		},
+		{
+			name:  "orphan sale",
+			sales: []saleRecord{sale("tx1", saleSold, old)},
+			want:  []string{issueOrphanSale + ":tx1"},
+		},
+		{
+			name:         "sale of a purchase still running",
+			sales:        []saleRecord{sale("tx1", saleSold, recent)},
+			wantInFlight: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := runAudit(now, tt.sales, tt.bonuses, tt.records, tt.pending, tt.dead)
+
+			var found []string
+			for _, issue := range report.Issues {
+				found = append(found, issue.Kind+":"+issue.TransactionID)
+			}
+			if !slices.Equal(found, tt.want) {
+				t.Fatalf("issues %v, want %v", found, tt.want)
+			}
+			if report.InFlight != tt.wantInFlight {
+				t.Fatalf("%d in flight, want %d", report.InFlight, tt.wantInFlight)
+			}
+		})
+	}
+}
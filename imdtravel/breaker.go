@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops calling a dependency after FailureThreshold
+// consecutive failures. Once Cooldown has passed it lets a single probe
+// through (half-open) and closes again after SuccessThreshold successes.
+type CircuitBreaker struct {
+	Name             string
+	FailureThreshold int
+	SuccessThreshold int
+	Cooldown         time.Duration
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	probing   bool
+}
+
+type BreakerStatus struct {
+	Name                string       `json:"name"`
+	State               breakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	FailureThreshold    int          `json:"failure_threshold"`
+	SuccessThreshold    int          `json:"success_threshold"`
+	Cooldown            string       `json:"cooldown"`
+	OpenedAt            time.Time    `json:"opened_at,omitzero"`
+}
+
+var (
+	airlinesHubBreaker = newCircuitBreaker("airlineshub")
+	exchangeBreaker    = newCircuitBreaker("exchange")
+	fidelityBreaker    = newCircuitBreaker("fidelity")
+)
+
+func newCircuitBreaker(name string) *CircuitBreaker {
+	prefix := strings.ToUpper(name) + "_BREAKER_"
+	return &CircuitBreaker{
+		Name:             name,
+		FailureThreshold: getEnvInt(prefix+"FAILURE_THRESHOLD", 5),
+		SuccessThreshold: getEnvInt(prefix+"SUCCESS_THRESHOLD", 1),
+		Cooldown:         getEnvDuration(prefix+"COOLDOWN", 10*time.Second),
+		state:            breakerClosed,
+	}
+}
+
+// execute runs fn unless the circuit is open. Only failures of the dependency
+// itself count against it; client errors such as a 404 do not. A call cut
+// short because ctx, the caller's own budget, ran out says nothing about the
+// dependency and is not counted either way.
+func (cb *CircuitBreaker) execute(ctx context.Context, fn func() error) error {
+	if err := cb.allow(); err != nil {
+		return err
+	}
+
+	err := fn()
+	switch {
+	case err != nil && ctx.Err() != nil:
+		cb.onAbandoned()
+	case isDependencyFailure(err):
+		cb.onFailure()
+	default:
+		cb.onSuccess()
+	}
+	return err
+}
+
+func (cb *CircuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.Cooldown {
+			breakerRejectionsTotal.Inc(cb.Name)
+			return errCircuitOpen
+		}
+		cb.transition(breakerHalfOpen)
+		cb.probing = true
+		return nil
+	case breakerHalfOpen:
+		if cb.probing {
+			breakerRejectionsTotal.Inc(cb.Name)
+			return errCircuitOpen
+		}
+		cb.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (cb *CircuitBreaker) onSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures = 0
+	if cb.state != breakerHalfOpen {
+		return
+	}
+
+	cb.probing = false
+	cb.successes++
+	if cb.successes >= cb.SuccessThreshold {
+		cb.transition(breakerClosed)
+	}
+}
+
+// onAbandoned frees the half-open probe slot so the next call can probe.
+func (cb *CircuitBreaker) onAbandoned() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+}
+
+func (cb *CircuitBreaker) onFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.FailureThreshold {
+		cb.transition(breakerOpen)
+	}
+}
+
+func (cb *CircuitBreaker) transition(state breakerState) {
+	if cb.state == state {
+		return
+	}
+
+	logger.Warn("circuit breaker state changed", "dependency", cb.Name, "from", cb.state, "to", state)
+	cb.state = state
+	cb.successes = 0
+	cb.probing = false
+	if state == breakerOpen {
+		cb.openedAt = time.Now()
+	}
+	if state == breakerClosed {
+		cb.failures = 0
+	}
+}
+
+func (cb *CircuitBreaker) stateValue() float64 {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerHalfOpen:
+		return 1
+	case breakerOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (cb *CircuitBreaker) status() BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := BreakerStatus{
+		Name:                cb.Name,
+		State:               cb.state,
+		ConsecutiveFailures: cb.failures,
+		FailureThreshold:    cb.FailureThreshold,
+		SuccessThreshold:    cb.SuccessThreshold,
+		Cooldown:            cb.Cooldown.String(),
+	}
+	if cb.state != breakerClosed {
+		status.OpenedAt = cb.openedAt
+	}
+	return status
+}
+
+// circuit reports the breaker state for the dependencies listed in /readyz.
+func (cb *CircuitBreaker) circuit() string {
+	return string(cb.status().State)
+}
+
+func circuitBreakersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, []BreakerStatus{
+		airlinesHubBreaker.status(),
+		exchangeBreaker.status(),
+		fidelityBreaker.status(),
+	}, http.StatusOK)
+}
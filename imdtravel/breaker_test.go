@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		Name:             "test",
+		FailureThreshold: 2,
+		SuccessThreshold: 1,
+		Cooldown:         time.Minute,
+		state:            breakerClosed,
+	}
+}
+
+func TestBreakerIgnoresCallerDeadline(t *testing.T) {
+	cb := newTestBreaker()
+
+	// The purchase budget (X-Request-Timeout) is already spent.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	for range 5 {
+		err := cb.execute(ctx, func() error {
+			return &url.Error{Op: "Get", URL: "http://airlineshub/flight", Err: ctx.Err()}
+		})
+		if err == nil {
+			t.Fatal("execute returned nil, want the call's error")
+		}
+	}
+
+	status := cb.status()
+	if status.State != breakerClosed || status.ConsecutiveFailures != 0 {
+		t.Fatalf("breaker is %s with %d failures, want closed with 0", status.State, status.ConsecutiveFailures)
+	}
+}
+
+func TestBreakerCountsPerCallTimeout(t *testing.T) {
+	cb := newTestBreaker()
+	ctx := context.Background()
+
+	for range 2 {
+		cb.execute(ctx, func() error {
+			// flightTimeout and the other per-call timeouts derive their
+			// own context, so the caller's is still alive.
+			callCtx, cancel := context.WithTimeout(ctx, time.Nanosecond)
+			defer cancel()
+			<-callCtx.Done()
+			return &url.Error{Op: "Get", URL: "http://airlineshub/flight", Err: callCtx.Err()}
+		})
+	}
+
+	if state := cb.status().State; state != breakerOpen {
+		t.Fatalf("breaker is %s, want open", state)
+	}
+}
+
+func TestBreakerAbandonedProbeFreesHalfOpen(t *testing.T) {
+	cb := newTestBreaker()
+	cb.state = breakerOpen
+	cb.openedAt = time.Now().Add(-2 * cb.Cooldown)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cb.execute(ctx, func() error { return ctx.Err() })
+
+	if err := cb.execute(context.Background(), func() error { return nil }); err != nil {
+		t.Fatalf("probe after an abandoned one: %v", err)
+	}
+	if state := cb.status().State; state != breakerClosed {
+		t.Fatalf("breaker is %s, want closed", state)
+	}
+}
+
+func TestBreakerStateMachine(t *testing.T) {
+	cb := newTestBreaker()
+	ctx := context.Background()
+	serverError := func() error { return &StatusError{Code: http.StatusServiceUnavailable} }
+
+	// Client errors are the caller's fault and leave the circuit closed.
+	for range 3 {
+		cb.execute(ctx, func() error { return &StatusError{Code: http.StatusNotFound} })
+	}
+	if status := cb.status(); status.State != breakerClosed || status.ConsecutiveFailures != 0 {
+		t.Fatalf("after 404s: %s with %d failures, want closed with 0", status.State, status.ConsecutiveFailures)
+	}
+
+	// A success resets the count, so only consecutive failures open it.
+	cb.execute(ctx, serverError)
+	cb.execute(ctx, func() error { return nil })
+	cb.execute(ctx, serverError)
+	if state := cb.status().State; state != breakerClosed {
+		t.Fatalf("after non-consecutive failures: %s, want closed", state)
+	}
+	cb.execute(ctx, serverError)
+	if state := cb.status().State; state != breakerOpen {
+		t.Fatalf("after %d consecutive failures: %s, want open", cb.FailureThreshold, state)
+	}
+
+	called := false
+	if err := cb.execute(ctx, func() error { called = true; return nil }); !errors.Is(err, errCircuitOpen) || called {
+		t.Fatalf("open circuit: got %v, called %t; want %v without calling", err, called, errCircuitOpen)
+	}
+
+	// After the cooldown a single probe goes through; a failure reopens.
+	cb.openedAt = time.Now().Add(-2 * cb.Cooldown)
+	cb.execute(ctx, serverError)
+	if state := cb.status().State; state != breakerOpen {
+		t.Fatalf("after a failed probe: %s, want open", state)
+	}
+
+	cb.openedAt = time.Now().Add(-2 * cb.Cooldown)
+	probe := make(chan struct{})
+	probed := make(chan error)
+	go func() {
+		probed <- cb.execute(ctx, func() error { <-probe; return nil })
+	}()
+	for cb.status().State != breakerHalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	if err := cb.execute(ctx, func() error { return nil }); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("second call while probing: got %v, want %v", err, errCircuitOpen)
+	}
+	close(probe)
+	if err := <-probed; err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if state := cb.status().State; state != breakerClosed {
+		t.Fatalf("after a successful probe: %s, want closed", state)
+	}
+}
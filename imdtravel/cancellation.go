@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"platform"
+)
+
+// A customer cancels a completed purchase through /cancelTicket: the sale is
+// cancelled in AirlinesHub, the bonus is reversed in Fidelity and the BRL
+// value is refunded at the exchange rate of the purchase.
+
+const (
+	bonusReversed = "reversed"
+	bonusDropped  = "dropped"
+	bonusNone     = "none"
+	bonusFailed   = "failed"
+)
+
+type CancelTicketRequest struct {
+	TransactionID string `json:"transaction_id"`
+	User          string `json:"user"`
+}
+
+type CancellationReceipt struct {
+	ID            string    `json:"cancellation_id"`
+	TransactionID string    `json:"transaction_id"`
+	SagaID        string    `json:"saga_id"`
+	User          string    `json:"user"`
+	Flight        string    `json:"flight"`
+	Day           string    `json:"day"`
+	Cabin         string    `json:"cabin,omitempty"`
+	ValueUSD      float64   `json:"value_usd"`
+	ExchangeRate  float64   `json:"exchange_rate"`
+	RefundBRL     float64   `json:"refund_brl"`
+	BonusPoints   int       `json:"bonus_points"`
+	BonusStatus   string    `json:"bonus_status"`
+	BonusError    string    `json:"bonus_error,omitempty"`
+	CancelledAt   time.Time `json:"cancelled_at"`
+}
+
+type ReverseBonusRequest struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+// Cancellations of the same transaction run one at a time, which keeps a
+// single receipt per purchase; each running one holds a channel closed when
+// it ends.
+var (
+	cancellations   = make(map[string]chan struct{})
+	cancellationsMu sync.Mutex
+)
+
+func cancelTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CancelTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.TransactionID == "" || req.User == "" {
+		respondError(w, "Missing required fields: transaction_id, user", http.StatusBadRequest)
+		return
+	}
+
+	unlock, err := lockCancellation(r.Context(), req.TransactionID)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	record, ok := purchases.completed(req.TransactionID)
+	if !ok || record.User != req.User {
+		respondError(w, "Purchase not found", http.StatusNotFound)
+		return
+	}
+
+	ctx := platform.WithLogAttrs(r.Context(),
+		slog.String("saga", record.SagaID),
+		slog.String("user", record.User),
+		slog.String("transaction_id", record.TransactionID))
+	// Once the sale is cancelled the rest must finish even if the caller
+	// has gone away.
+	ctx = context.WithoutCancel(ctx)
+
+	if record.Cancellation != nil {
+		receipt := record.Cancellation
+		if receipt.BonusStatus == bonusFailed {
+			logger.InfoContext(ctx, "ticket already cancelled, retrying the bonus reversal")
+			receipt = reverseCancelledBonus(ctx, record)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		respondJSON(w, receipt, http.StatusOK)
+		return
+	}
+
+	logger.InfoContext(ctx, "processing ticket cancellation")
+
+	stepCtx, span := platform.StartSpan(ctx, "cancel_sale", platform.SpanKindInternal)
+	err = runCompensation(stepCtx, func(ctx context.Context) error {
+		return cancelTicket(ctx, record.TransactionID)
+	})
+	span.End(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to cancel sale", "error", err)
+		respondError(w, fmt.Sprintf("Failed to cancel ticket: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	record.Cancellation = &CancellationReceipt{
+		ID:            rand.Text(),
+		TransactionID: record.TransactionID,
+		SagaID:        record.SagaID,
+		User:          record.User,
+		Flight:        record.Flight,
+		Day:           record.Day,
+		Cabin:         record.Cabin,
+		ValueUSD:      record.ValueUSD,
+		ExchangeRate:  record.ExchangeRate,
+		RefundBRL:     math.Round(record.ValueUSD*record.ExchangeRate*100) / 100,
+		BonusPoints:   record.BonusPoints,
+		CancelledAt:   time.Now(),
+	}
+	receipt := reverseCancelledBonus(ctx, record)
+
+	logger.InfoContext(ctx, "ticket cancelled",
+		"refund_brl", receipt.RefundBRL, "bonus_status", receipt.BonusStatus)
+	respondJSON(w, receipt, http.StatusOK)
+}
+
+// reverseCancelledBonus reverses the bonus of a cancelled purchase and
+// stores the outcome in its receipt. A reversal that failed is tried again
+// when the customer repeats the cancellation.
+func reverseCancelledBonus(ctx context.Context, record PurchaseRecord) *CancellationReceipt {
+	receipt := *record.Cancellation
+
+	stepCtx, span := platform.StartSpan(ctx, "reverse_bonus", platform.SpanKindInternal)
+	status, err := reverseBonus(stepCtx, record.TransactionID)
+	span.End(err)
+	receipt.BonusStatus, receipt.BonusError = status, ""
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to reverse bonus, the audit will report it", "error", err)
+		receipt.BonusError = err.Error()
+	}
+
+	record.Cancellation = &receipt
+	if err := purchases.put(record); err != nil {
+		logger.ErrorContext(ctx, "failed to persist purchase record", "error", err)
+	}
+	return &receipt
+}
+
+// lockCancellation waits until no other cancellation of transactionID is
+// running and returns the function that ends this one. It fails only if ctx
+// is done while waiting.
+func lockCancellation(ctx context.Context, transactionID string) (func(), error) {
+	cancellationsMu.Lock()
+	for {
+		running, ok := cancellations[transactionID]
+		if !ok {
+			break
+		}
+		cancellationsMu.Unlock()
+		select {
+		case <-running:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		cancellationsMu.Lock()
+	}
+	done := make(chan struct{})
+	cancellations[transactionID] = done
+	cancellationsMu.Unlock()
+
+	return func() {
+		cancellationsMu.Lock()
+		delete(cancellations, transactionID)
+		cancellationsMu.Unlock()
+		close(done)
+	}, nil
+}
+
+// reverseBonus undoes the bonus of a transaction. A bonus still queued is
+// dropped, and Fidelity is asked anyway in case an attempt already landed.
+func reverseBonus(ctx context.Context, transactionID string) (string, error) {
+	dropped := dropQueuedBonus(ctx, transactionID)
+
+	err := bonusRetryPolicy.Do(ctx, func(ctx context.Context, attempt int) error {
+		return postBonusReversal(ctx, transactionID)
+	})
+	switch {
+	case err == nil:
+		return bonusReversed, nil
+	case !isBonusNotFound(err):
+		return bonusFailed, err
+	case dropped:
+		return bonusDropped, nil
+	default:
+		return bonusNone, nil
+	}
+}
+
+func postBonusReversal(ctx context.Context, transactionID string) error {
+	url := fmt.Sprintf("%s/bonus/reverse", fidelityURL)
+
+	jsonData, err := json.Marshal(ReverseBonusRequest{TransactionID: transactionID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := postJSON(ctx, bonusTimeout, url, jsonData)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
+	}
+	return nil
+}
+
+// reverseRegisteredBonus compensates a bonus registration that failed on
+// our side but may have reached Fidelity. A bonus Fidelity never registered
+// has nothing to undo.
+func reverseRegisteredBonus(ctx context.Context, transactionID string) error {
+	if err := postBonusReversal(ctx, transactionID); err != nil && !isBonusNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func isBonusNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound
+}
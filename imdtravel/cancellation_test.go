@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockCancellationIsPerTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	unlock, err := lockCancellation(ctx, "tx-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow cancellation of tx-1 must not hold up another transaction.
+	other, err := lockCancellation(ctx, "tx-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	locked := make(chan func(), 1)
+	go func() {
+		next, _ := lockCancellation(ctx, "tx-1")
+		locked <- next
+	}()
+	select {
+	case <-locked:
+		t.Fatal("second cancellation of tx-1 ran alongside the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case next := <-locked:
+		next()
+	case <-time.After(time.Second):
+		t.Fatal("second cancellation of tx-1 did not run after the first ended")
+	}
+}
+
+func TestLockCancellationGivesUpWhenCallerLeaves(t *testing.T) {
+	unlock, err := lockCancellation(context.Background(), "tx-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := lockCancellation(ctx, "tx-1"); err == nil {
+		t.Fatal("lockCancellation succeeded while tx-1 was still being cancelled")
+	}
+}
+
+func TestRepeatedCancelRetriesFailedBonusReversal(t *testing.T) {
+	useTestQueues(t)
+	previous := purchases
+	purchases = newPurchaseLog()
+	t.Cleanup(func() { purchases = previous })
+
+	var reversals atomic.Int32
+	useTestFidelity(t, func(w http.ResponseWriter, r *http.Request) {
+		reversals.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	purchases.store(PurchaseRecord{
+		SagaID:        "saga-1",
+		User:          "u1",
+		Success:       true,
+		TransactionID: "tx-1",
+		BonusPoints:   500,
+		Cancellation: &CancellationReceipt{
+			ID:            "c-1",
+			TransactionID: "tx-1",
+			BonusStatus:   bonusFailed,
+			BonusError:    "fidelity down",
+		},
+	})
+
+	cancel := func() CancellationReceipt {
+		t.Helper()
+		body := strings.NewReader(`{"transaction_id":"tx-1","user":"u1"}`)
+		recorder := httptest.NewRecorder()
+		cancelTicketHandler(recorder, httptest.NewRequest(http.MethodPost, "/cancelTicket", body))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", recorder.Code, recorder.Body)
+		}
+		var receipt CancellationReceipt
+		if err := json.NewDecoder(recorder.Body).Decode(&receipt); err != nil {
+			t.Fatal(err)
+		}
+		return receipt
+	}
+
+	receipt := cancel()
+	if receipt.ID != "c-1" || receipt.BonusStatus != bonusReversed || receipt.BonusError != "" {
+		t.Fatalf("receipt %+v, want c-1 with the bonus reversed", receipt)
+	}
+	if record, _ := purchases.completed("tx-1"); record.Cancellation.BonusStatus != bonusReversed {
+		t.Fatalf("stored bonus status %q, want %q", record.Cancellation.BonusStatus, bonusReversed)
+	}
+
+	// Once reversed the receipt is replayed without asking Fidelity again.
+	cancel()
+	if got := reversals.Load(); got != 1 {
+		t.Fatalf("%d reversals sent, want 1", got)
+	}
+}
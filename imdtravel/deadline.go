@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"platform"
+)
+
+// statusClientClosedRequest is the nginx convention for a caller that went
+// away before the response was ready.
+const statusClientClosedRequest = 499
+
+const (
+	flightTimeout   = 5 * time.Second
+	exchangeTimeout = 1 * time.Second
+	sellTimeout     = 2 * time.Second
+	bonusTimeout    = 5 * time.Second
+	cancelTimeout   = 5 * time.Second
+)
+
+var (
+	httpClient = &http.Client{Transport: platform.TracingTransport{Base: http.DefaultTransport}}
+
+	purchaseBudget = getEnvDuration("PURCHASE_TIMEOUT", 10*time.Second)
+)
+
+// purchaseContext bounds a purchase by PURCHASE_TIMEOUT or by the shorter
+// X-Request-Timeout sent by the client (a duration such as "3s", or
+// milliseconds). The context is also cancelled when the client disconnects.
+func purchaseContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	budget := purchaseBudget
+	if header := r.Header.Get("X-Request-Timeout"); header != "" {
+		timeout, err := parseRequestTimeout(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		budget = min(budget, timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	return ctx, cancel, nil
+}
+
+func parseRequestTimeout(value string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid X-Request-Timeout header: %q", value)
+}
+
+// failureStatus picks the status for a failed step, reporting an expired
+// budget or a departed client instead of blaming the dependency.
+func failureStatus(ctx context.Context, status int, message string) (int, BuyTicketResponse) {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, errorResponse("Purchase deadline exceeded: " + message)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return statusClientClosedRequest, errorResponse("Client closed request: " + message)
+	default:
+		return status, errorResponse(message)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"platform"
+)
+
+const (
+	latencySamples       = 100
+	defaultHedgeDelay    = 300 * time.Millisecond
+	hedgeDelayPercentile = 0.95
+)
+
+// latencyTracker keeps the most recent successful call latencies.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+var (
+	flightLatencies = &latencyTracker{}
+
+	// The automatic delay waits for enough samples to trust the p95, and
+	// never drops below a floor: with AirlinesHub answering in a few
+	// milliseconds, the p95 alone would hedge nearly every request.
+	hedgeMinSamples = getEnvInt("FLIGHT_HEDGE_MIN_SAMPLES", 20)
+	hedgeMinDelay   = getEnvDuration("FLIGHT_HEDGE_MIN_DELAY", 50*time.Millisecond)
+)
+
+func (t *latencyTracker) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < latencySamples {
+		t.samples = append(t.samples, d)
+		return
+	}
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % latencySamples
+}
+
+func (t *latencyTracker) percentile(p float64) (time.Duration, bool) {
+	t.mu.Lock()
+	sorted := slices.Clone(t.samples)
+	t.mu.Unlock()
+
+	if len(sorted) == 0 || len(sorted) < hedgeMinSamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	return sorted[int(p*float64(len(sorted)-1))], true
+}
+
+// flightHedgeDelay reads FLIGHT_HEDGE_DELAY: "off" disables hedging, a
+// duration fixes the delay, and "auto" (the default) uses the observed p95,
+// but no less than FLIGHT_HEDGE_MIN_DELAY.
+func flightHedgeDelay() (time.Duration, bool) {
+	switch value := os.Getenv("FLIGHT_HEDGE_DELAY"); value {
+	case "off":
+		return 0, false
+	case "", "auto":
+		if p95, ok := flightLatencies.percentile(hedgeDelayPercentile); ok {
+			return max(p95, hedgeMinDelay), true
+		}
+		return defaultHedgeDelay, true
+	default:
+		return parseDuration(value, defaultHedgeDelay), true
+	}
+}
+
+type hedgeResult[T any] struct {
+	value T
+	err   error
+}
+
+// hedge calls fn and, if it has not finished after delay, calls it a second
+// time. The first success wins and the other call is cancelled. A call that
+// fails before the delay is not hedged; the retry policy handles that case.
+func hedge[T any](ctx context.Context, delay time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult[T], 2)
+	launch := func() {
+		go func() {
+			value, err := fn(ctx)
+			results <- hedgeResult[T]{value: value, err: err}
+		}()
+	}
+
+	launch()
+	inFlight := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			logger.InfoContext(ctx, "no answer yet, sending hedged request", "delay_ms", platform.DurationMillis(delay))
+			hedgedRequestsTotal.Inc()
+			platform.AddSpanEvent(ctx, "hedge", map[string]string{"delay": delay.String()})
+			launch()
+			inFlight++
+		case result := <-results:
+			inFlight--
+			if result.err == nil {
+				return result.value, nil
+			}
+			if inFlight == 0 {
+				return result.value, result.err
+			}
+		}
+	}
+}
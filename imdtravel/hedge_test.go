@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlightHedgeDelay(t *testing.T) {
+	previous := flightLatencies
+	t.Cleanup(func() { flightLatencies = previous })
+
+	tests := []struct {
+		name    string
+		setting string
+		samples int
+		latency time.Duration
+		want    time.Duration
+	}{
+		{"too few samples", "auto", hedgeMinSamples - 1, 10 * time.Millisecond, defaultHedgeDelay},
+		{"observed p95", "", hedgeMinSamples, 120 * time.Millisecond, 120 * time.Millisecond},
+		{"fast dependency", "auto", latencySamples, time.Millisecond, hedgeMinDelay},
+		{"fixed delay below the floor", "10ms", latencySamples, time.Millisecond, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FLIGHT_HEDGE_DELAY", tt.setting)
+			flightLatencies = &latencyTracker{}
+			for range tt.samples {
+				flightLatencies.observe(tt.latency)
+			}
+
+			delay, ok := flightHedgeDelay()
+			if !ok || delay != tt.want {
+				t.Fatalf("delay %v (hedging %t), want %v", delay, ok, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("FLIGHT_HEDGE_DELAY", "off")
+	if _, ok := flightHedgeDelay(); ok {
+		t.Fatal("hedging enabled with FLIGHT_HEDGE_DELAY=off")
+	}
+}
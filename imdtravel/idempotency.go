@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyTTL           = 24 * time.Hour
+	idempotencySweepInterval = time.Minute
+)
+
+type idempotencyEntry struct {
+	fingerprint string
+	status      int
+	response    BuyTicketResponse
+	final       bool
+	done        chan struct{}
+	createdAt   time.Time
+}
+
+// idempotencyKeys lives in memory only: a restart forgets every key, and a
+// retry after it runs the purchase again. saleReference keeps that retry from
+// taking a second seat.
+var (
+	idempotencyKeys   = make(map[string]*idempotencyEntry)
+	idempotencyKeysMu sync.Mutex
+)
+
+func requestFingerprint(req BuyTicketRequest) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%t", req.Flight, req.Day, req.Cabin, req.User, req.FT)
+}
+
+// saleReference is the AirlinesHub reference of a purchase. With an
+// idempotency key it is derived from the key and the user, so a retry that
+// runs the purchase again, after an outcome that was not final or a restart
+// that lost the key, gets back the seat the first run still holds instead of
+// taking another. Without a key every saga has its own.
+func saleReference(key, user, sagaID string) string {
+	if key == "" {
+		return sagaID
+	}
+	sum := sha256.Sum256([]byte(user + "\x00" + key))
+	return "key-" + hex.EncodeToString(sum[:16])
+}
+
+// beginIdempotent returns the entry stored for key and whether the caller owns
+// it. The owner must call finishIdempotent; everyone else waits on entry.done
+// and, unless the outcome is final, calls beginIdempotent again.
+func beginIdempotent(key string, req BuyTicketRequest) (*idempotencyEntry, bool) {
+	idempotencyKeysMu.Lock()
+	defer idempotencyKeysMu.Unlock()
+
+	if entry, exists := idempotencyKeys[key]; exists {
+		return entry, false
+	}
+
+	entry := &idempotencyEntry{
+		fingerprint: requestFingerprint(req),
+		done:        make(chan struct{}),
+		createdAt:   time.Now(),
+	}
+	idempotencyKeys[key] = entry
+	return entry, true
+}
+
+// finishIdempotent stores the outcome for key. Only final outcomes are kept
+// for replay: a success, or a client error the purchase itself decided.
+// Server-side failures, a client that went away (499) and anything cut short
+// by an expired purchase context are forgotten: requests already waiting
+// race to run the purchase again, and so does a later retry.
+func finishIdempotent(ctx context.Context, key string, entry *idempotencyEntry, status int, response BuyTicketResponse) {
+	idempotencyKeysMu.Lock()
+	entry.status = status
+	entry.response = response
+	entry.final = isFinalOutcome(ctx, status)
+	if !entry.final {
+		delete(idempotencyKeys, key)
+	}
+	idempotencyKeysMu.Unlock()
+
+	close(entry.done)
+}
+
+func isFinalOutcome(ctx context.Context, status int) bool {
+	switch {
+	case status >= http.StatusOK && status < http.StatusMultipleChoices:
+		return true
+	case status >= http.StatusInternalServerError || status == statusClientClosedRequest:
+		return false
+	default:
+		return ctx.Err() == nil
+	}
+}
+
+// sweepIdempotencyKeys forgets finished keys older than idempotencyTTL until
+// ctx is done.
+func sweepIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expireIdempotencyKeys(now)
+		}
+	}
+}
+
+func expireIdempotencyKeys(now time.Time) {
+	idempotencyKeysMu.Lock()
+	defer idempotencyKeysMu.Unlock()
+
+	for key, entry := range idempotencyKeys {
+		if now.Sub(entry.createdAt) > idempotencyTTL && isClosed(entry.done) {
+			delete(idempotencyKeys, key)
+		}
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
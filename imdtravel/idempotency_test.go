@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useTestIdempotencyKeys gives the test an empty set of idempotency keys,
+// so repeated runs do not find the keys of earlier ones.
+func useTestIdempotencyKeys(t *testing.T) {
+	t.Helper()
+
+	idempotencyKeysMu.Lock()
+	previous := idempotencyKeys
+	idempotencyKeys = make(map[string]*idempotencyEntry)
+	idempotencyKeysMu.Unlock()
+	t.Cleanup(func() {
+		idempotencyKeysMu.Lock()
+		idempotencyKeys = previous
+		idempotencyKeysMu.Unlock()
+	})
+}
+
+func TestFinishIdempotentKeepsOnlyFinalOutcomes(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status int
+		kept   bool
+	}{
+		{"success", context.Background(), http.StatusOK, true},
+		{"invalid flight", context.Background(), http.StatusBadRequest, true},
+		{"success after the client left", cancelled, http.StatusOK, true},
+		{"dependency down", context.Background(), http.StatusServiceUnavailable, false},
+		{"deadline exceeded", context.Background(), http.StatusGatewayTimeout, false},
+		{"client closed request", cancelled, statusClientClosedRequest, false},
+		{"client error after the client left", cancelled, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "finish-" + tt.name
+			req := BuyTicketRequest{Flight: "AA123", Day: "2025-11-15", User: "u1"}
+			entry, owner := beginIdempotent(key, req)
+			if !owner {
+				t.Fatal("first request does not own the key")
+			}
+			finishIdempotent(tt.ctx, key, entry, tt.status, BuyTicketResponse{})
+
+			replayed, owner := beginIdempotent(key, req)
+			if owner == tt.kept {
+				t.Fatalf("retry owns the key = %t, want %t", owner, !tt.kept)
+			}
+			if tt.kept && replayed.status != tt.status {
+				t.Fatalf("replayed status %d, want %d", replayed.status, tt.status)
+			}
+		})
+	}
+}
+
+func TestExpireIdempotencyKeys(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	req := BuyTicketRequest{Flight: "AA123", Day: "2025-11-15", User: "u1"}
+	entry, _ := beginIdempotent("expire-finished", req)
+	finishIdempotent(context.Background(), "expire-finished", entry, http.StatusOK, BuyTicketResponse{})
+	beginIdempotent("expire-running", req)
+
+	expireIdempotencyKeys(time.Now().Add(idempotencyTTL + time.Minute))
+
+	if _, owner := beginIdempotent("expire-finished", req); !owner {
+		t.Fatal("finished key older than the TTL was not forgotten")
+	}
+	if _, owner := beginIdempotent("expire-running", req); owner {
+		t.Fatal("key of a purchase still running was forgotten")
+	}
+}
+
+func TestDuplicateWaitsForOriginalOutcome(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	req := BuyTicketRequest{Flight: "AA123", Day: "2025-11-15", User: "u1"}
+	entry, owner := beginIdempotent("duplicate", req)
+	if !owner {
+		t.Fatal("first request does not own the key")
+	}
+
+	duplicate, owner := beginIdempotent("duplicate", req)
+	if owner || duplicate != entry {
+		t.Fatal("duplicate request was not handed the original entry")
+	}
+	select {
+	case <-duplicate.done:
+		t.Fatal("duplicate saw an outcome before the original finished")
+	default:
+	}
+
+	response := BuyTicketResponse{Success: true, TransactionID: "tx-1"}
+	finishIdempotent(context.Background(), "duplicate", entry, http.StatusOK, response)
+
+	<-duplicate.done
+	if duplicate.status != http.StatusOK || duplicate.response.TransactionID != "tx-1" {
+		t.Fatalf("duplicate got %d %+v, want the original outcome", duplicate.status, duplicate.response)
+	}
+}
+
+func TestDuplicateRunsPurchaseAfterUnfinishedOriginal(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	var lookups atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	previous := airlinesHubURL
+	airlinesHubURL = server.URL
+	t.Cleanup(func() { airlinesHubURL = previous })
+
+	req := BuyTicketRequest{Flight: "AA123", Day: "2025-11-15", User: "u1"}
+	entry, _ := beginIdempotent("unfinished", req)
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		body := strings.NewReader(`{"flight":"AA123","day":"2025-11-15","user":"u1"}`)
+		r := httptest.NewRequest(http.MethodPost, "/buyTicket", body)
+		r.Header.Set("Idempotency-Key", "unfinished")
+		buyTicketHandler(w, r)
+	}()
+
+	// The original's client went away: the duplicate must not get its 499.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	finishIdempotent(cancelled, "unfinished", entry, statusClientClosedRequest, BuyTicketResponse{})
+	<-done
+
+	if w.Code == statusClientClosedRequest || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("duplicate got %d, replayed %q; want the purchase run again", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+	if lookups.Load() == 0 {
+		t.Fatal("duplicate did not run the purchase")
+	}
+}
+
+func TestDuplicateWhoseClientLeftIsRecordedAs499(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	req := BuyTicketRequest{Flight: "AA123", Day: "2025-11-15", User: "u1"}
+	entry, _ := beginIdempotent("waiting", req)
+	t.Cleanup(func() { finishIdempotent(context.Background(), "waiting", entry, http.StatusOK, BuyTicketResponse{}) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := strings.NewReader(`{"flight":"AA123","day":"2025-11-15","user":"u1"}`)
+	r := httptest.NewRequestWithContext(ctx, http.MethodPost, "/buyTicket", body)
+	r.Header.Set("Idempotency-Key", "waiting")
+	w := httptest.NewRecorder()
+	buyTicketHandler(w, r)
+
+	if w.Code != statusClientClosedRequest {
+		t.Fatalf("status %d, want %d", w.Code, statusClientClosedRequest)
+	}
+}
+
+func TestBuyTicketRejectsReusedKey(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	original := BuyTicketRequest{Flight: "AA123", Day: "2025-11-15", User: "u1"}
+	entry, _ := beginIdempotent("reused", original)
+	finishIdempotent(context.Background(), "reused", entry, http.StatusOK, BuyTicketResponse{Success: true})
+
+	body := strings.NewReader(`{"flight":"AA123","day":"2025-11-16","user":"u1"}`)
+	r := httptest.NewRequest(http.MethodPost, "/buyTicket", body)
+	r.Header.Set("Idempotency-Key", "reused")
+	w := httptest.NewRecorder()
+	buyTicketHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestBuyTicketReplaysFinishedPurchase(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	req := BuyTicketRequest{Flight: "AA123", Day: "2025-11-15", User: "u1", RequestID: "replayed"}
+	entry, _ := beginIdempotent("replayed", req)
+	finishIdempotent(context.Background(), "replayed", entry, http.StatusOK,
+		BuyTicketResponse{Success: true, TransactionID: "tx-1"})
+
+	body := strings.NewReader(`{"flight":"AA123","day":"2025-11-15","user":"u1","request_id":"replayed"}`)
+	w := httptest.NewRecorder()
+	buyTicketHandler(w, httptest.NewRequest(http.MethodPost, "/buyTicket", body))
+
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("status %d, replayed %q; want 200 replayed", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+	if !strings.Contains(w.Body.String(), `"transaction_id":"tx-1"`) {
+		t.Fatalf("body %s does not carry the original transaction", w.Body.String())
+	}
+}
+
+func TestRetriedKeyKeepsSaleReference(t *testing.T) {
+	useTestIdempotencyKeys(t)
+	var references []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flight":
+			json.NewEncoder(w).Encode(FlightResponse{Flight: "AA123", Day: "2025-11-15", Value: 500})
+		case "/reserve":
+			var req ReserveRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			references = append(references, req.Reference)
+			http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	previous := airlinesHubURL
+	airlinesHubURL = server.URL
+	t.Cleanup(func() { airlinesHubURL = previous })
+
+	// The first run fails in a way that is not final, so the retry with the
+	// same key runs the purchase again and must ask for the same seat.
+	buy := func(key string) {
+		body := strings.NewReader(`{"flight":"AA123","day":"2025-11-15","user":"u1"}`)
+		r := httptest.NewRequest(http.MethodPost, "/buyTicket", body)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		buyTicketHandler(httptest.NewRecorder(), r)
+	}
+	buy("retried")
+	buy("retried")
+	buy("")
+
+	if len(references) != 3 {
+		t.Fatalf("AirlinesHub got %d holds, want 3", len(references))
+	}
+	if references[0] != references[1] {
+		t.Fatalf("retry held with reference %q, want %q", references[1], references[0])
+	}
+	if references[2] == references[0] {
+		t.Fatal("purchase without a key reused the reference of a keyed one")
+	}
+}
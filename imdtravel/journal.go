@@ -0,0 +1,67 @@
+package main
+
+import "platform"
+
+const (
+	journalPut    = "put"
+	journalDelete = "delete"
+)
+
+type journalRecord struct {
+	Op    string        `json:"op"`
+	Key   string        `json:"key"`
+	Bonus *PendingBonus `json:"bonus,omitempty"`
+}
+
+// bonusJournal is an append-only log of pending bonus changes. Replaying it
+// from the start rebuilds the last state of every key.
+type bonusJournal struct {
+	path string
+	log  *platform.AppendLog[journalRecord]
+}
+
+func openBonusJournal(path string) (*bonusJournal, map[string]*PendingBonus, error) {
+	entries := make(map[string]*PendingBonus)
+	err := platform.ReplayAppendLog(path, func(record journalRecord) {
+		switch record.Op {
+		case journalPut:
+			if record.Bonus != nil {
+				entries[record.Key] = record.Bonus
+			}
+		case journalDelete:
+			delete(entries, record.Key)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log, err := platform.OpenAppendLog(path, journalSnapshot(entries))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &bonusJournal{path: path, log: log}, entries, nil
+}
+
+// journalSnapshot is the compacted journal of entries: one put per key.
+func journalSnapshot(entries map[string]*PendingBonus) []journalRecord {
+	records := make([]journalRecord, 0, len(entries))
+	for key, bonus := range entries {
+		records = append(records, journalRecord{Op: journalPut, Key: key, Bonus: bonus})
+	}
+	return records
+}
+
+func (j *bonusJournal) put(key string, bonus PendingBonus) error {
+	return j.log.Append(journalRecord{Op: journalPut, Key: key, Bonus: &bonus})
+}
+
+func (j *bonusJournal) delete(key string) error {
+	return j.log.Append(journalRecord{Op: journalDelete, Key: key})
+}
+
+// close compacts the journal down to entries and closes it. Later writes fail
+// with platform.ErrLogClosed.
+func (j *bonusJournal) close(entries map[string]*PendingBonus) error {
+	return j.log.Close(journalSnapshot(entries))
+}
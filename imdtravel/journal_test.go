@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBonusJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending_bonuses.log")
+	journal, _, err := openBonusJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.put("tx-1", PendingBonus{User: "u1", Bonus: 500, TransactionID: "tx-1"})
+	journal.put("tx-2", PendingBonus{User: "u2", Bonus: 300, TransactionID: "tx-2"})
+	journal.put("tx-1", PendingBonus{User: "u1", Bonus: 500, TransactionID: "tx-1", Attempts: 2})
+	journal.delete("tx-2")
+	journal.put("tx-3", PendingBonus{User: "u3", Bonus: 100, TransactionID: "tx-3"})
+
+	// Reopened without close, as after a crash: every change is replayed.
+	journal, entries, err := openBonusJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.close(entries)
+
+	if len(entries) != 2 || entries["tx-1"] == nil || entries["tx-3"] == nil {
+		t.Fatalf("replayed %v, want tx-1 and tx-3", entries)
+	}
+	if entries["tx-1"].Attempts != 2 {
+		t.Fatalf("tx-1 has %d attempts, want its last put with 2", entries["tx-1"].Attempts)
+	}
+
+	// The journal was compacted to one put per key.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 2 {
+		t.Fatalf("journal has %d lines after compaction, want 2", lines)
+	}
+}
+
+func TestBonusJournalSkipsTruncatedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending_bonuses.log")
+	journal, entries, err := openBonusJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.put("tx-1", PendingBonus{User: "u1", Bonus: 500, TransactionID: "tx-1"})
+	entries["tx-1"] = &PendingBonus{User: "u1", Bonus: 500, TransactionID: "tx-1"}
+	journal.close(entries)
+
+	// A crash in the middle of a write leaves a partial line behind.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"put","key":"tx-2","bonus":{"user":"u2","bo`)
+	file.Close()
+
+	journal, entries, err = openBonusJournal(path)
+	if err != nil {
+		t.Fatalf("reopen with a truncated line: %v", err)
+	}
+	if len(entries) != 1 || entries["tx-1"] == nil {
+		t.Fatalf("replayed %v, want only tx-1", entries)
+	}
+
+	// Compaction dropped the partial line, so the next record is readable.
+	journal.put("tx-3", PendingBonus{User: "u3", Bonus: 100, TransactionID: "tx-3"})
+	journal, entries, err = openBonusJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.close(entries)
+	if len(entries) != 2 || entries["tx-3"] == nil {
+		t.Fatalf("replayed %v, want tx-1 and tx-3", entries)
+	}
+}
+
+func TestBonusJournalRejectsCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending_bonuses.log")
+	journal, entries, err := openBonusJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.put("tx-1", PendingBonus{User: "u1", Bonus: 500, TransactionID: "tx-1"})
+	entries["tx-1"] = &PendingBonus{User: "u1", Bonus: 500, TransactionID: "tx-1"}
+	journal.close(entries)
+
+	// A complete line that does not parse is not a torn write: skipping it
+	// would drop a pending bonus for good once the journal is compacted.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append([]byte(`{"op":"put","key":"tx-0","bonus":{"user":"u0","bo`+"\n"), data...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := openBonusJournal(path); err == nil {
+		t.Fatal("reopen with a corrupt line in the middle: got nil error")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+		t.Fatal("a failed replay compacted the journal")
+	}
+}
+
+func TestPendingQueuesRestoredOnRestart(t *testing.T) {
+	previous := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = previous })
+
+	if err := loadPendingBonuses(); err != nil {
+		t.Fatal(err)
+	}
+	addPendingBonus(context.Background(), "u1", 500, "tx-1")
+	addDeadLetter("tx-2", PendingBonus{User: "u2", Bonus: 300, TransactionID: "tx-2", Attempts: 20, CreatedAt: time.Now()})
+	closePendingBonuses()
+
+	pendingBonusesMu.Lock()
+	pendingBonuses = make(map[string]*PendingBonus)
+	pendingBonusesMu.Unlock()
+	deadLettersMu.Lock()
+	deadLetters = make(map[string]*PendingBonus)
+	deadLettersMu.Unlock()
+
+	if err := loadPendingBonuses(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closePendingBonuses)
+
+	pending := listPendingBonuses()
+	if len(pending) != 1 || pending[0].Key != "tx-1" || pending[0].Bonus != 500 || pending[0].CreatedAt.IsZero() {
+		t.Fatalf("pending after restart: %+v, want tx-1 with 500 points", pending)
+	}
+	dead := listDeadLetters()
+	if len(dead) != 1 || dead[0].Key != "tx-2" || dead[0].Attempts != 20 || dead[0].DeadAt.IsZero() {
+		t.Fatalf("dead letters after restart: %+v, want tx-2 with its attempts and DeadAt", dead)
+	}
+}
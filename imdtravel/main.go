@@ -2,22 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
+
+	"platform"
 )
 
 type BuyTicketRequest struct {
-	Flight string `json:"flight"`
-	Day    string `json:"day"`
-	User   string `json:"user"`
-	FT     bool   `json:"ft,omitempty"`
+	Flight    string `json:"flight"`
+	Day       string `json:"day"`
+	User      string `json:"user"`
+	Cabin     string `json:"cabin,omitempty"`
+	FT        bool   `json:"ft,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 type BuyTicketResponse struct {
@@ -27,6 +38,7 @@ type BuyTicketResponse struct {
 	TransactionID string  `json:"transaction_id,omitempty"`
 	Flight        string  `json:"flight,omitempty"`
 	Day           string  `json:"day,omitempty"`
+	Cabin         string  `json:"cabin,omitempty"`
 	ValueUSD      float64 `json:"value_usd,omitempty"`
 	ValueBRL      float64 `json:"value_brl,omitempty"`
 	ExchangeRate  float64 `json:"exchange_rate,omitempty"`
@@ -40,32 +52,84 @@ type FlightResponse struct {
 	Value  float64 `json:"value"`
 }
 
-type SellRequest struct {
-	Flight string `json:"flight"`
-	Day    string `json:"day"`
+type ReserveRequest struct {
+	Flight    string `json:"flight"`
+	Day       string `json:"day"`
+	Cabin     string `json:"cabin,omitempty"`
+	Reference string `json:"reference,omitempty"`
+}
+
+type HoldRequest struct {
+	HoldID string `json:"hold_id"`
+}
+
+type HoldResponse struct {
+	HoldID    string    `json:"hold_id"`
+	Status    string    `json:"status"`
+	Cabin     string    `json:"cabin"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
 }
 
 type SellResponse struct {
 	ID string `json:"id"`
 }
 
+type CancelRequest struct {
+	ID string `json:"id"`
+}
+
 type BonusRequest struct {
-	User  string `json:"user"`
-	Bonus int    `json:"bonus"`
+	User          string `json:"user"`
+	Bonus         int    `json:"bonus"`
+	TransactionID string `json:"transaction_id,omitempty"`
 }
 
 type PendingBonus struct {
-	User        string
-	Bonus       int
-	Attempts    int
-	LastAttempt time.Time
-	CreatedAt   time.Time
+	User          string    `json:"user"`
+	Bonus         int       `json:"bonus"`
+	TransactionID string    `json:"transaction_id,omitempty"`
+	Attempts      int       `json:"attempts"`
+	LastAttempt   time.Time `json:"last_attempt"`
+	LastError     string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	DeadAt        time.Time `json:"dead_at,omitzero"`
+	RequeuedAt    time.Time `json:"requeued_at,omitzero"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Traceparent   string    `json:"traceparent,omitempty"`
+}
+
+var (
+	// errSoldOut means AirlinesHub has no seats left in the requested cabin.
+	errSoldOut = errors.New("não há mais assentos disponíveis para este voo")
+	// errHoldExpired means the seat hold ran out, or AirlinesHub no longer
+	// knows it, before the sale was confirmed.
+	errHoldExpired = errors.New("a reserva do assento expirou antes da confirmação da venda")
+)
+
+// SaleRejectedError is a 4xx from an AirlinesHub sale endpoint, such as an
+// unknown cabin. The request itself is wrong, so retrying cannot succeed.
+type SaleRejectedError struct {
+	Status *StatusError
+}
+
+func (e *SaleRejectedError) Error() string {
+	return remoteErrorMessage(e.Status)
+}
+
+func (e *SaleRejectedError) Unwrap() error {
+	return e.Status
 }
 
 var (
+	logger = platform.Init("imdtravel")
+
 	airlinesHubURL = getEnv("AIRLINESHUB_URL", "http://localhost:8081")
 	exchangeURL    = getEnv("EXCHANGE_URL", "http://localhost:8082")
 	fidelityURL    = getEnv("FIDELITY_URL", "http://localhost:8083")
+	dataDir        = getEnv("DATA_DIR", "data")
+	// adminToken is sent to the AirlinesHub and Fidelity listings the audit
+	// reads, which only answer to it.
+	adminToken = os.Getenv("ADMIN_TOKEN")
 
 	pendingBonuses   = make(map[string]*PendingBonus)
 	pendingBonusesMu sync.RWMutex
@@ -81,15 +145,92 @@ func getEnv(key string, defaultValue string) string {
 	return defaultValue
 }
 
-func main() {
-	http.HandleFunc("/buyTicket", buyTicketHandler)
-	http.HandleFunc("/health", healthHandler)
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
 
-	go processPendingBonuses()
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func main() {
+	healthcheck := flag.Bool("healthcheck", false, "check /livez of the running service and exit")
+	flag.Parse()
 
 	port := ":8080"
-	log.Printf("IMDTravel service starting on port %s", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	if *healthcheck {
+		os.Exit(platform.RunHealthcheck(port))
+	}
+
+	http.HandleFunc("/buyTicket", platform.Instrument("/buyTicket", platform.Traced(buyTicketHandler)))
+	http.HandleFunc("/cancelTicket", platform.Instrument("/cancelTicket", platform.Traced(cancelTicketHandler)))
+	http.HandleFunc("/searchFlights", platform.Instrument("/searchFlights", platform.Traced(searchFlightsHandler)))
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/livez", platform.LivezHandler)
+	// The service is ready once the pending bonus queue is loaded, and stays
+	// ready while AirlinesHub answers. Without Exchange or Fidelity purchases
+	// still work through the exchange history and the pending queue, so they
+	// only degrade it.
+	http.HandleFunc("/readyz", platform.ReadyzWithDependencies(
+		platform.Dependency{Name: "airlineshub", URL: airlinesHubURL, Required: true, Circuit: airlinesHubBreaker.circuit},
+		platform.Dependency{Name: "exchange", URL: exchangeURL, Circuit: exchangeBreaker.circuit},
+		platform.Dependency{Name: "fidelity", URL: fidelityURL, Circuit: fidelityBreaker.circuit},
+	))
+	http.HandleFunc("/breakers", circuitBreakersHandler)
+	http.HandleFunc("/metrics", platform.MetricsHandler)
+	registerAdminHandlers()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := loadPendingBonuses(); err != nil {
+		logger.Error("failed to load pending bonus queue", "error", err)
+		os.Exit(1)
+	}
+	if err := loadPurchases(); err != nil {
+		logger.Error("failed to load purchase log", "error", err)
+		os.Exit(1)
+	}
+	platform.StartTracing()
+	platform.Ready.Store(true)
+
+	processorDone := make(chan struct{})
+	go func() {
+		defer close(processorDone)
+		processPendingBonuses(ctx)
+	}()
+	go sweepIdempotencyKeys(ctx)
+
+	logger.Info("service starting", "port", port)
+	err := platform.Serve(ctx, port)
+	if err != nil {
+		logger.Error("server stopped", "error", err)
+	}
+
+	stop()
+	<-processorDone
+	closePendingBonuses()
+	if err := purchases.close(); err != nil {
+		logger.Error("failed to close purchase log", "error", err)
+	}
+	platform.StopTracing()
+	logger.Info("service stopped")
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -114,56 +255,177 @@ func buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Processing ticket purchase: flight=%s, day=%s, user=%s, ft=%t", req.Flight, req.Day, req.User, req.FT)
-
-	flight, err := getFlightInfo(req.Flight, req.Day, req.FT)
+	ctx, cancel, err := purchaseContext(r)
 	if err != nil {
-		log.Printf("Error getting flight info: %v", err)
-		respondError(w, fmt.Sprintf("Failed to get flight info: %v", err), http.StatusInternalServerError)
+		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	defer cancel()
 
-	exchangeRate, err := getExchangeRate(req.FT)
-	if err != nil {
-		log.Printf("Error getting exchange rate: %v", err)
-		respondError(w, fmt.Sprintf("Failed to get exchange rate: %v", err), http.StatusInternalServerError)
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = req.RequestID
+	}
+
+	if key == "" {
+		status, response := purchaseTicket(ctx, "", req)
+		respondJSON(w, response, status)
 		return
 	}
 
+	entry, owner := beginIdempotent(key, req)
+	for !owner {
+		if entry.fingerprint != requestFingerprint(req) {
+			respondError(w, "Idempotency key was already used with a different request", http.StatusUnprocessableEntity)
+			return
+		}
+
+		select {
+		case <-entry.done:
+		case <-r.Context().Done():
+			// Nobody reads the answer, but the metrics should not count a 200.
+			respondError(w, "Client closed request while waiting for the original", statusClientClosedRequest)
+			return
+		}
+		if entry.final {
+			logger.InfoContext(r.Context(), "duplicate request, replaying original response", "idempotency_key", key)
+			w.Header().Set("Idempotent-Replayed", "true")
+			respondJSON(w, entry.response, entry.status)
+			return
+		}
+		// The original ended in a way a retry may fix, such as its client
+		// leaving (499), and dropped the key: one waiter runs it again.
+		logger.InfoContext(r.Context(), "original request did not finish, retrying", "idempotency_key", key)
+		entry, owner = beginIdempotent(key, req)
+	}
+
+	status, response := purchaseTicket(ctx, key, req)
+	finishIdempotent(ctx, key, entry, status, response)
+	respondJSON(w, response, status)
+}
+
+// purchaseTicket runs the purchase saga. key is the idempotency key of the
+// request, if any.
+func purchaseTicket(ctx context.Context, key string, req BuyTicketRequest) (status int, response BuyTicketResponse) {
+	saga := newSaga(req.User)
+	reference := saleReference(key, req.User, saga.ID)
+	ctx = platform.WithLogAttrs(ctx,
+		slog.String("saga", saga.ID),
+		slog.String("user", req.User),
+		slog.String("flight", req.Flight),
+		slog.String("day", req.Day))
+	defer func() {
+		recordPurchase(ctx, saga, reference, req, status, response)
+	}()
+	logger.InfoContext(ctx, "processing ticket purchase", "ft", req.FT)
+
+	stepCtx, span := platform.StartSpan(ctx, "get_flight", platform.SpanKindInternal)
+	flight, err := getFlightInfo(stepCtx, req.Flight, req.Day, req.FT)
+	span.End(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get flight info", "error", err)
+		saga.abort(ctx, "get_flight", err)
+		return failureStatus(ctx, http.StatusInternalServerError, fmt.Sprintf("Failed to get flight info: %v", err))
+	}
+	saga.record("get_flight", nil)
+
+	stepCtx, span = platform.StartSpan(ctx, "hold_seat", platform.SpanKindInternal)
+	hold, err := holdSeat(stepCtx, req.Flight, req.Day, req.Cabin, reference, req.FT)
+	span.End(err)
+	var rejected *SaleRejectedError
+	if errors.Is(err, errSoldOut) {
+		logger.WarnContext(ctx, "flight sold out", "cabin", req.Cabin)
+		saga.abort(ctx, "hold_seat", err)
+		return failureStatus(ctx, http.StatusConflict, err.Error())
+	}
+	if errors.As(err, &rejected) {
+		logger.WarnContext(ctx, "seat hold rejected", "cabin", req.Cabin, "error", err)
+		saga.abort(ctx, "hold_seat", err)
+		return failureStatus(ctx, http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to hold seat", "error", err)
+		saga.abort(ctx, "hold_seat", err)
+		return failureStatus(ctx, http.StatusServiceUnavailable, err.Error())
+	}
+	// Releasing also cancels the sale once the hold was confirmed.
+	saga.record("hold_seat", func(ctx context.Context) error {
+		return releaseSeat(ctx, hold.HoldID)
+	})
+
+	stepCtx, span = platform.StartSpan(ctx, "get_exchange_rate", platform.SpanKindInternal)
+	exchangeRate, err := getExchangeRate(stepCtx, req.FT)
+	span.End(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get exchange rate", "error", err)
+		saga.abort(ctx, "get_exchange_rate", err)
+		return failureStatus(ctx, http.StatusInternalServerError, fmt.Sprintf("Failed to get exchange rate: %v", err))
+	}
+	saga.record("get_exchange_rate", nil)
+
 	valueBRL := flight.Value * exchangeRate
 
-	transactionID, err := sellTicket(req.Flight, req.Day, req.FT)
+	stepCtx, span = platform.StartSpan(ctx, "confirm_sale", platform.SpanKindInternal)
+	transactionID, err := confirmSale(stepCtx, hold.HoldID, req.FT)
+	span.End(err)
+	if errors.Is(err, errHoldExpired) {
+		logger.WarnContext(ctx, "seat hold expired before confirmation", "hold_id", hold.HoldID)
+		saga.abort(ctx, "confirm_sale", err)
+		return failureStatus(ctx, http.StatusConflict, err.Error())
+	}
+	if errors.As(err, &rejected) {
+		logger.WarnContext(ctx, "sale confirmation rejected", "hold_id", hold.HoldID, "error", err)
+		saga.abort(ctx, "confirm_sale", err)
+		return failureStatus(ctx, http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
-		log.Printf("Error selling ticket: %v", err)
-		respondError(w, err.Error(), http.StatusServiceUnavailable)
-		return
+		logger.ErrorContext(ctx, "failed to confirm sale", "error", err)
+		saga.abort(ctx, "confirm_sale", err)
+		return failureStatus(ctx, http.StatusServiceUnavailable, err.Error())
 	}
+	saga.record("confirm_sale", nil)
 
 	bonusPoints := int(math.Round(flight.Value))
 	bonusStatus := "processed"
 
+	stepCtx, span = platform.StartSpan(ctx, "register_bonus", platform.SpanKindInternal)
 	if req.FT {
-		if err := registerBonusWithRetry(req.User, bonusPoints, 3); err != nil {
-			log.Printf("Warning: Failed to register bonus immediately: %v", err)
-			log.Printf("[FAULT TOLERANCE] Adding bonus to pending queue")
-			addPendingBonus(req.User, bonusPoints)
+		if err := registerBonusWithRetry(stepCtx, req.User, bonusPoints, transactionID); err != nil {
+			logger.WarnContext(ctx, "failed to register bonus, adding it to the pending queue", "error", err)
+			fallbacksTotal.Inc("pending_bonus")
+			span.AddEvent("fallback", map[string]string{"kind": "pending_bonus", "error": err.Error()})
+			addPendingBonus(stepCtx, req.User, bonusPoints, transactionID)
 			bonusStatus = "pending"
 		}
+		span.End(nil)
 	} else {
-		if err := registerBonus(req.User, bonusPoints, req.FT); err != nil {
-			log.Printf("Error registering bonus: %v", err)
-			respondError(w, fmt.Sprintf("Failed to register bonus: %v", err), http.StatusInternalServerError)
-			return
+		err := registerBonus(stepCtx, req.User, bonusPoints, transactionID, req.FT)
+		span.End(err)
+		if err != nil {
+			logger.ErrorContext(ctx, "failed to register bonus", "error", err)
+			message := fmt.Sprintf("Failed to register bonus: %v", err)
+			// A timeout or a crash may come after Fidelity stored the
+			// bonus, so it is reversed along with the sale.
+			compErr := saga.abortUncertain(ctx, "register_bonus", err, func(ctx context.Context) error {
+				return reverseRegisteredBonus(ctx, transactionID)
+			})
+			if compErr != nil {
+				message += fmt.Sprintf(" (ticket %s could not be fully undone: %v)", transactionID, compErr)
+			} else {
+				message += fmt.Sprintf(" (ticket %s was cancelled)", transactionID)
+			}
+			return failureStatus(ctx, http.StatusInternalServerError, message)
 		}
-
 	}
+	saga.record("register_bonus", nil)
 
-	response := BuyTicketResponse{
+	response = BuyTicketResponse{
 		Success:       true,
 		Message:       "Ticket purchased successfully",
 		TransactionID: transactionID,
 		Flight:        req.Flight,
 		Day:           req.Day,
+		Cabin:         hold.Cabin,
 		ValueUSD:      flight.Value,
 		ValueBRL:      valueBRL,
 		ExchangeRate:  exchangeRate,
@@ -171,104 +433,140 @@ func buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 		BonusStatus:   bonusStatus,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-	log.Printf("Purchase completed: transaction_id=%s, bonus_status=%s", transactionID, bonusStatus)
+	logger.InfoContext(ctx, "purchase completed", "transaction_id", transactionID, "bonus_status", bonusStatus)
+	return http.StatusOK, response
 }
 
-func getFlightInfo(flight, day string, ft bool) (*FlightResponse, error) {
+func getFlightInfo(ctx context.Context, flight, day string, ft bool) (*FlightResponse, error) {
 	url := fmt.Sprintf("%s/flight?flight=%s&day=%s", airlinesHubURL, flight, day)
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Printf("[R1] Attempt 1 failed (timeout/net error): %v", err)
-		if !ft {
-			return nil, fmt.Errorf("request failed: %w", err)
+	fetch := func(ctx context.Context) (*FlightResponse, error) {
+		ctx, cancel := context.WithTimeout(ctx, flightTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
-		log.Println("[FT R1] FT is ON: Using Retry Strategy (3 more attempts).")
 
-		const maxRetries = 4
-		var lastErr = err
+		start := time.Now()
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		defer resp.Body.Close()
 
-		for attempt := 2; attempt <= maxRetries; attempt++ {
-			time.Sleep(500 * time.Millisecond)
-			log.Printf("[FT R1] Attempt %d/%d...", attempt, maxRetries)
+		if resp.StatusCode != http.StatusOK {
+			return nil, newStatusError(resp)
+		}
 
-			resp, err := client.Get(url)
-			if err != nil {
-				lastErr = fmt.Errorf("attempt %d: request failed (timeout/net error): %w", attempt, err)
-				log.Println(lastErr)
-				continue
-			}
+		var flightResp FlightResponse
+		if err := json.NewDecoder(resp.Body).Decode(&flightResp); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		flightLatencies.observe(time.Since(start))
+		return &flightResp, nil
+	}
 
-			if resp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(resp.Body)
-				resp.Body.Close()
-				return nil, fmt.Errorf("service returned non-OK status %d: %s", resp.StatusCode, string(body))
-			}
+	if !ft {
+		flightResp, err := fetch(ctx)
+		if err != nil {
+			logger.WarnContext(ctx, "flight lookup failed", "attempt", 1, "error", err)
+		}
+		return flightResp, err
+	}
 
-			var flightResp FlightResponse
-			if err := json.NewDecoder(resp.Body).Decode(&flightResp); err != nil {
-				resp.Body.Close()
-				return nil, fmt.Errorf("attempt %d: failed to decode response: %w", attempt, err)
+	var flightResp *FlightResponse
+	err := flightRetryPolicy.Do(ctx, func(ctx context.Context, attempt int) error {
+		err := airlinesHubBreaker.execute(ctx, func() error {
+			delay, ok := flightHedgeDelay()
+			if !ok {
+				var err error
+				flightResp, err = fetch(ctx)
+				return err
 			}
-			resp.Body.Close()
 
-			log.Printf("[FT R1] Success on attempt %d", attempt)
-			return &flightResp, nil
+			var err error
+			flightResp, err = hedge(ctx, delay, fetch)
+			return err
+		})
+		if err != nil {
+			logger.WarnContext(ctx, "flight lookup attempt failed",
+				"attempt", attempt, "max_attempts", flightRetryPolicy.MaxAttempts, "error", err)
+		} else if attempt > 1 {
+			logger.InfoContext(ctx, "flight lookup succeeded after retry", "attempt", attempt)
 		}
-		return nil, fmt.Errorf("all retries failed for Request 1: %w", lastErr)
+		return err
+	})
+	if err == nil {
+		return flightResp, nil
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		defer resp.Body.Close()
-		return nil, fmt.Errorf("service returned status %d: %s", resp.StatusCode, string(body))
+	if errors.Is(err, errCircuitOpen) {
+		logger.WarnContext(ctx, "airlineshub circuit is open, skipping flight lookup")
+		return nil, fmt.Errorf("o serviço de voos está temporariamente indisponível: %w", err)
 	}
-
-	var flightResp FlightResponse
-	if err := json.NewDecoder(resp.Body).Decode(&flightResp); err != nil {
-		defer resp.Body.Close()
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if isTransportError(err) {
+		return nil, fmt.Errorf("all retries failed for Request 1: %w", err)
 	}
-	defer resp.Body.Close()
-
-	return &flightResp, nil
+	return nil, err
 }
 
-func getExchangeRate(ft bool) (float64, error) {
+func getExchangeRate(ctx context.Context, ft bool) (float64, error) {
 	url := fmt.Sprintf("%s/convert", exchangeURL)
 
-	client := &http.Client{Timeout: 1 * time.Second}
-	resp, err := client.Get(url)
+	fetch := func(ctx context.Context) (float64, error) {
+		ctx, cancel := context.WithTimeout(ctx, exchangeTimeout)
+		defer cancel()
 
-	tryFallback := func(originalErr error) (float64, error) {
-		if !ft {
-			return 0, originalErr
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create request: %w", err)
 		}
-		avg, fallbackErr := getAverageExchangeRate()
-		if fallbackErr != nil {
-			return 0, fmt.Errorf("%w (fallback falhou: %v)", originalErr, fallbackErr)
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("request failed: %w", err)
 		}
-		log.Printf("⚠️ Erro no Exchange: %v. Usando média do histórico: %.4f", originalErr, avg)
-		return avg, nil
-	}
+		defer resp.Body.Close()
 
-	if err != nil {
-		return tryFallback(fmt.Errorf("request failed: %w", err))
+		if resp.StatusCode != http.StatusOK {
+			return 0, newStatusError(resp)
+		}
+
+		var rate float64
+		if err := json.NewDecoder(resp.Body).Decode(&rate); err != nil {
+			return 0, fmt.Errorf("failed to decode response: %w", err)
+		}
+		return rate, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return tryFallback(fmt.Errorf("service returned status %d: %s", resp.StatusCode, string(body)))
+	if !ft {
+		rate, err := fetch(ctx)
+		if err != nil {
+			return 0, err
+		}
+		updateExchangeHistory(rate)
+		return rate, nil
 	}
 
 	var rate float64
-	if err := json.NewDecoder(resp.Body).Decode(&rate); err != nil {
-		return tryFallback(fmt.Errorf("failed to decode response: %w", err))
+	err := exchangeRetryPolicy.Do(ctx, func(ctx context.Context, attempt int) error {
+		return exchangeBreaker.execute(ctx, func() error {
+			var err error
+			rate, err = fetch(ctx)
+			return err
+		})
+	})
+	if err != nil {
+		avg, fallbackErr := getAverageExchangeRate()
+		if fallbackErr != nil {
+			return 0, fmt.Errorf("%w (fallback falhou: %v)", err, fallbackErr)
+		}
+		logger.WarnContext(ctx, "exchange rate unavailable, using historical average", "error", err, "rate", avg)
+		fallbacksTotal.Inc("exchange_history")
+		platform.AddSpanEvent(ctx, "fallback", map[string]string{"kind": "exchange_history", "error": err.Error()})
+		return avg, nil
 	}
 
 	updateExchangeHistory(rate)
@@ -304,61 +602,140 @@ func getAverageExchangeRate() (float64, error) {
 	return math.Round(avg*1000) / 1000, nil
 }
 
-func sellTicket(flight, day string, ft bool) (string, error) {
-	url := fmt.Sprintf("%s/sell", airlinesHubURL)
+// holdSeat reserves a seat for the saga. The hold carries the cabin
+// AirlinesHub resolved, and the sale is only confirmed once the remaining
+// steps succeed.
+func holdSeat(ctx context.Context, flight, day, cabin, reference string, ft bool) (HoldResponse, error) {
+	var hold HoldResponse
+	err := postSale(ctx, "/reserve", ReserveRequest{
+		Flight:    flight,
+		Day:       day,
+		Cabin:     cabin,
+		Reference: reference,
+	}, &hold, ft)
+	return hold, err
+}
 
-	reqBody := SellRequest{
-		Flight: flight,
-		Day:    day,
-	}
+// confirmSale turns a hold into a sale. The transaction keeps the hold ID.
+func confirmSale(ctx context.Context, holdID string, ft bool) (string, error) {
+	var sale SellResponse
+	err := postSale(ctx, "/confirm", HoldRequest{HoldID: holdID}, &sale, ft)
+	return sale.ID, err
+}
 
-	jsonData, err := json.Marshal(reqBody)
+// postSale calls an AirlinesHub sale endpoint (Request 3). With ft it is
+// retried behind the circuit breaker and fails gracefully.
+func postSale(ctx context.Context, path string, body, out any, ft bool) error {
+	url := airlinesHubURL + path
+
+	jsonData, err := json.Marshal(body)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		if ft {
-			if os.IsTimeout(err) {
-				log.Printf("[FT SELL] High latency detected (>2s). Fail gracefully.")
-				return "", fmt.Errorf("o sistema de vendas está instável no momento devido à alta latência. Por favor, tente novamente em alguns instantes")
+	post := func(ctx context.Context) error {
+		resp, err := postJSON(ctx, sellTimeout, url, jsonData)
+		if err != nil {
+			return fmt.Errorf("request failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated:
+		case http.StatusConflict:
+			return errSoldOut
+		case http.StatusGone:
+			return errHoldExpired
+		case http.StatusNotFound:
+			// The hold was just created, so if AirlinesHub cannot find it
+			// it lost the hold; the customer's request is not at fault.
+			if path == "/confirm" {
+				return errHoldExpired
+			}
+			return &SaleRejectedError{Status: newStatusError(resp)}
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return newStatusError(resp)
+		default:
+			if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+				return &SaleRejectedError{Status: newStatusError(resp)}
 			}
-			log.Printf("[FT SELL] Network error: %v. Fail gracefully.", err)
-			return "", fmt.Errorf("o serviço de vendas está temporariamente indisponível")
+			return newStatusError(resp)
 		}
 
-		return "", fmt.Errorf("request failed: %w", err)
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	}
+
+	if !ft {
+		return post(ctx)
+	}
+
+	// Retrying is safe: AirlinesHub returns the original hold for a repeated
+	// reference, and confirming a confirmed hold changes nothing.
+	err = sellRetryPolicy.Do(ctx, func(ctx context.Context, attempt int) error {
+		return airlinesHubBreaker.execute(ctx, func() error {
+			return post(ctx)
+		})
+	})
+	var rejected *SaleRejectedError
+	if err == nil || errors.Is(err, errSoldOut) || errors.Is(err, errHoldExpired) || errors.As(err, &rejected) {
+		return err
+	}
+	fallbacksTotal.Inc("sell_graceful_failure")
+	platform.AddSpanEvent(ctx, "fallback", map[string]string{"kind": "sell_graceful_failure", "error": err.Error()})
+
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		logger.WarnContext(ctx, "airlineshub circuit is open, failing sale gracefully", "path", path)
+		return fmt.Errorf("o serviço de vendas está temporariamente indisponível")
+	case isTimeout(err):
+		logger.WarnContext(ctx, "sale timed out, failing gracefully", "path", path, "timeout_ms", platform.DurationMillis(sellTimeout))
+		return fmt.Errorf("o sistema de vendas está instável no momento devido à alta latência. Por favor, tente novamente em alguns instantes")
+	case isTransportError(err):
+		logger.WarnContext(ctx, "sale failed with a network error, failing gracefully", "path", path, "error", err)
+		return fmt.Errorf("o serviço de vendas está temporariamente indisponível")
+	case errors.As(err, &statusErr):
+		logger.WarnContext(ctx, "sale rejected, failing gracefully", "path", path, "status", statusErr.Code)
+		return fmt.Errorf("não foi possível processar a venda no momento (código %d)", statusErr.Code)
+	default:
+		return fmt.Errorf("erro interno ao processar confirmação de venda")
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		if ft {
-			log.Printf("[FT SELL] Service returned error status: %d", resp.StatusCode)
-			return "", fmt.Errorf("não foi possível processar a venda no momento (código %d)", resp.StatusCode)
-		}
-		return "", fmt.Errorf("service returned status %d: %s", resp.StatusCode, string(body))
+// releaseSeat gives a held seat back. A hold that was already confirmed is
+// cancelled instead, which also covers a confirmation whose reply was lost.
+func releaseSeat(ctx context.Context, holdID string) error {
+	url := fmt.Sprintf("%s/release", airlinesHubURL)
+
+	jsonData, err := json.Marshal(HoldRequest{HoldID: holdID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	var sellResp SellResponse
-	if err := json.NewDecoder(resp.Body).Decode(&sellResp); err != nil {
-		if ft {
-			return "", fmt.Errorf("erro interno ao processar confirmação de venda")
-		}
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	resp, err := postJSON(ctx, cancelTimeout, url, jsonData)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
 	}
+	defer resp.Body.Close()
 
-	return sellResp.ID, nil
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return cancelTicket(ctx, holdID)
+	default:
+		return newStatusError(resp)
+	}
 }
 
-func registerBonus(user string, bonus int, ft bool) error {
-	url := fmt.Sprintf("%s/bonus", fidelityURL)
+func cancelTicket(ctx context.Context, transactionID string) error {
+	url := fmt.Sprintf("%s/cancel", airlinesHubURL)
 
-	reqBody := BonusRequest{
-		User:  user,
-		Bonus: bonus,
+	reqBody := CancelRequest{
+		ID: transactionID,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -366,118 +743,167 @@ func registerBonus(user string, bonus int, ft bool) error {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := postJSON(ctx, cancelTimeout, url, jsonData)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("service returned status %d: %s", resp.StatusCode, string(body))
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(resp)
 	}
 
 	return nil
 }
 
-func registerBonusWithRetry(user string, bonus int, maxRetries int) error {
-	var lastErr error
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		err := registerBonus(user, bonus, true)
-		if err == nil {
-			if attempt > 1 {
-				log.Printf("[FAULT TOLERANCE] Bonus registered after %d attempts", attempt)
-			}
-			return nil
+func registerBonus(ctx context.Context, user string, bonus int, transactionID string, ft bool) error {
+	url := fmt.Sprintf("%s/bonus", fidelityURL)
+
+	reqBody := BonusRequest{
+		User:          user,
+		Bonus:         bonus,
+		TransactionID: transactionID,
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	register := func(ctx context.Context) error {
+		resp, err := postJSON(ctx, bonusTimeout, url, jsonData)
+		if err != nil {
+			return fmt.Errorf("request failed: %w", err)
 		}
+		defer resp.Body.Close()
 
-		lastErr = err
-		log.Printf("[FAULT TOLERANCE] Bonus registration attempt %d/%d failed: %v",
-			attempt, maxRetries, err)
-		if attempt < maxRetries {
-			backoff := time.Duration(100*attempt) * time.Millisecond
-			time.Sleep(backoff)
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			return newStatusError(resp)
 		}
+		return nil
+	}
+
+	if !ft {
+		return register(ctx)
 	}
+	return fidelityBreaker.execute(ctx, func() error {
+		return register(ctx)
+	})
+}
 
-	return fmt.Errorf("all %d retry attempts failed: %w", maxRetries, lastErr)
+func registerBonusWithRetry(ctx context.Context, user string, bonus int, transactionID string) error {
+	err := bonusRetryPolicy.Do(ctx, func(ctx context.Context, attempt int) error {
+		err := registerBonus(ctx, user, bonus, transactionID, true)
+		if err != nil {
+			logger.WarnContext(ctx, "bonus registration attempt failed",
+				"attempt", attempt, "max_attempts", bonusRetryPolicy.MaxAttempts, "error", err)
+		} else if attempt > 1 {
+			logger.InfoContext(ctx, "bonus registered after retry", "attempt", attempt)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("bonus registration failed: %w", err)
+	}
+	return nil
 }
 
-func addPendingBonus(user string, bonus int) {
-	key := fmt.Sprintf("%s_%d", user, time.Now().UnixNano())
-	pending := &PendingBonus{
-		User:        user,
-		Bonus:       bonus,
-		Attempts:    0,
-		LastAttempt: time.Time{},
-		CreatedAt:   time.Now(),
+// postJSON sends a POST bounded by both ctx and the per-attempt timeout. The
+// response body stays readable until the caller closes it.
+func postJSON(ctx context.Context, timeout time.Duration, url string, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
 
-	pendingBonusesMu.Lock()
-	pendingBonuses[key] = pending
-	pendingBonusesMu.Unlock()
-	log.Printf("[PENDING QUEUE] Added bonus for user %s: %d points (total pending: %d)",
-		user, bonus, len(pendingBonuses))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
 }
 
-func processPendingBonuses() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-	log.Println("[PENDING QUEUE] Background processor started")
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	for range ticker.C {
-		pendingBonusesMu.Lock()
-		if len(pendingBonuses) == 0 {
-			pendingBonusesMu.Unlock()
-			continue
-		}
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
 
-		log.Printf("[PENDING QUEUE] Processing %d pending bonuses", len(pendingBonuses))
-		keys := make([]string, 0, len(pendingBonuses))
-		for key := range pendingBonuses {
-			keys = append(keys, key)
-		}
-		pendingBonusesMu.Unlock()
-		for _, key := range keys {
-			pendingBonusesMu.RLock()
-			pending, exists := pendingBonuses[key]
-			pendingBonusesMu.RUnlock()
-			if !exists {
-				continue
-			}
-			if pending.Attempts >= 20 {
-				log.Printf("[PENDING QUEUE] Max attempts reached for %s, removing from queue", key)
-				pendingBonusesMu.Lock()
-				delete(pendingBonuses, key)
-				pendingBonusesMu.Unlock()
-				continue
-			}
+type StatusError struct {
+	Code int
+	Body string
+}
 
-			pending.Attempts++
-			pending.LastAttempt = time.Now()
+func newStatusError(resp *http.Response) *StatusError {
+	body, _ := io.ReadAll(resp.Body)
+	return &StatusError{Code: resp.StatusCode, Body: string(body)}
+}
 
-			err := registerBonus(pending.User, pending.Bonus, true)
-			if err == nil {
-				log.Printf("[PENDING QUEUE] Successfully processed bonus for user %s after %d attempts",
-					pending.User, pending.Attempts)
-				pendingBonusesMu.Lock()
-				delete(pendingBonuses, key)
-				pendingBonusesMu.Unlock()
-			} else {
-				log.Printf("[PENDING QUEUE] Attempt %d failed for user %s: %v",
-					pending.Attempts, pending.User, err)
-			}
-		}
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("service returned status %d: %s", e.Code, e.Body)
+}
+
+// remoteErrorMessage returns the message of a JSON error body, or the body.
+func remoteErrorMessage(err *StatusError) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(err.Body), &body) == nil && body.Error != "" {
+		return body.Error
 	}
+	return err.Body
 }
 
-func respondError(w http.ResponseWriter, message string, statusCode int) {
-	response := BuyTicketResponse{
+func isTimeout(err error) bool {
+	var timeoutErr interface{ Timeout() bool }
+	return errors.As(err, &timeoutErr) && timeoutErr.Timeout()
+}
+
+func isTransportError(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// isDependencyFailure reports whether err means the dependency itself is
+// unhealthy, as opposed to rejecting a bad request.
+func isDependencyFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, errSoldOut) || errors.Is(err, errHoldExpired) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError
+	}
+	return true
+}
+
+func errorResponse(message string) BuyTicketResponse {
+	return BuyTicketResponse{
 		Success: false,
 		Error:   message,
 	}
+}
+
+func respondJSON(w http.ResponseWriter, response any, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
+
+func respondError(w http.ResponseWriter, message string, statusCode int) {
+	respondJSON(w, errorResponse(message), statusCode)
+}
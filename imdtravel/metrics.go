@@ -0,0 +1,42 @@
+package main
+
+import (
+	"platform"
+)
+
+// IMDTravel's own metrics, served by platform.MetricsHandler alongside the
+// HTTP and fault metrics every service exports.
+
+var (
+	retriesTotal = platform.NewCounterVec("imdtravel_retries_total",
+		"Retries of outbound calls, by retry policy.", "policy")
+	fallbacksTotal = platform.NewCounterVec("imdtravel_fallbacks_total",
+		"Fault-tolerance fallbacks taken, by kind.", "kind")
+	hedgedRequestsTotal = platform.NewCounterVec("imdtravel_hedged_requests_total",
+		"Hedged second requests sent to AirlinesHub /flight.")
+	breakerRejectionsTotal = platform.NewCounterVec("imdtravel_circuit_breaker_rejections_total",
+		"Calls short-circuited by an open breaker, by dependency.", "dependency")
+	compensationsTotal = platform.NewCounterVec("imdtravel_saga_compensations_total",
+		"Saga compensations run, by step and outcome.", "step", "outcome")
+
+	_ = platform.NewGaugeFunc("imdtravel_pending_bonus_queue_depth",
+		"Bonuses waiting in the pending queue.", nil, func() []platform.Sample {
+			pendingBonusesMu.RLock()
+			defer pendingBonusesMu.RUnlock()
+			return []platform.Sample{{Value: float64(len(pendingBonuses))}}
+		})
+	_ = platform.NewGaugeFunc("imdtravel_dead_letter_queue_depth",
+		"Bonuses that exhausted their attempts.", nil, func() []platform.Sample {
+			deadLettersMu.RLock()
+			defer deadLettersMu.RUnlock()
+			return []platform.Sample{{Value: float64(len(deadLetters))}}
+		})
+	_ = platform.NewGaugeFunc("imdtravel_circuit_breaker_state",
+		"Circuit breaker state by dependency (0 closed, 1 half-open, 2 open).", []string{"dependency"}, func() []platform.Sample {
+			var samples []platform.Sample
+			for _, cb := range []*CircuitBreaker{airlinesHubBreaker, exchangeBreaker, fidelityBreaker} {
+				samples = append(samples, platform.Sample{Labels: []string{cb.Name}, Value: cb.stateValue()})
+			}
+			return samples
+		})
+)
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"platform"
+)
+
+var (
+	errQueueEntryNotFound = errors.New("queue entry not found")
+	errRetryInFlight      = errors.New("a retry of this entry is already running")
+)
+
+var (
+	pendingJournal *bonusJournal
+	// pendingAttempts holds the pending bonuses being sent to Fidelity right
+	// now, closed when the attempt ends. Guarded by pendingBonusesMu.
+	pendingAttempts = make(map[string]chan struct{})
+
+	deadLetters       = make(map[string]*PendingBonus)
+	deadLettersMu     sync.RWMutex
+	deadLetterJournal *bonusJournal
+)
+
+func loadPendingBonuses() error {
+	journal, entries, err := openBonusJournal(filepath.Join(dataDir, "pending_bonuses.log"))
+	if err != nil {
+		return err
+	}
+	deadJournal, deadEntries, err := openBonusJournal(filepath.Join(dataDir, "dead_letters.log"))
+	if err != nil {
+		return err
+	}
+
+	pendingBonusesMu.Lock()
+	pendingJournal = journal
+	pendingBonuses = entries
+	pendingBonusesMu.Unlock()
+
+	deadLettersMu.Lock()
+	deadLetterJournal = deadJournal
+	deadLetters = deadEntries
+	deadLettersMu.Unlock()
+
+	logger.Info("restored pending bonus queue",
+		"pending", len(entries), "dead_letters", len(deadEntries), "data_dir", dataDir)
+	return nil
+}
+
+func addPendingBonus(ctx context.Context, user string, bonus int, transactionID string) {
+	key := transactionID
+	if key == "" {
+		key = fmt.Sprintf("%s_%d", user, time.Now().UnixNano())
+	}
+	pending := &PendingBonus{
+		User:          user,
+		Bonus:         bonus,
+		TransactionID: transactionID,
+		Attempts:      0,
+		LastAttempt:   time.Time{},
+		CreatedAt:     time.Now(),
+	}
+	// Later attempts join the purchase's trace and keep its request ID.
+	if span := platform.SpanFromContext(ctx); span != nil {
+		pending.Traceparent = span.Traceparent()
+	}
+	pending.RequestID = platform.RequestIDFromContext(ctx)
+
+	pendingBonusesMu.Lock()
+	err := pendingJournal.put(key, *pending)
+	if errors.Is(err, platform.ErrLogClosed) {
+		pendingBonusesMu.Unlock()
+		if err := addLateDeadLetter(key, *pending); err != nil {
+			logger.ErrorContext(ctx, "failed to persist bonus queued after shutdown", "queue_key", key, "error", err)
+			return
+		}
+		logger.WarnContext(ctx, "bonus queued after shutdown, moved to dead letters", "queue_key", key, "bonus", bonus)
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to persist pending bonus", "queue_key", key, "error", err)
+	}
+	pendingBonuses[key] = pending
+	total := len(pendingBonuses)
+	pendingBonusesMu.Unlock()
+	logger.InfoContext(ctx, "bonus added to pending queue",
+		"queue_key", key, "bonus", bonus, "pending", total)
+}
+
+// processPendingBonuses retries due bonuses until ctx is done. An attempt
+// already in flight is allowed to finish.
+func processPendingBonuses(ctx context.Context) {
+	ticker := time.NewTicker(max(pendingRetryPolicy.InitialBackoff, time.Second))
+	defer ticker.Stop()
+	logger.Info("pending bonus processor started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("pending bonus processor stopped")
+			return
+		case <-ticker.C:
+		}
+
+		keys := duePendingBonusKeys(time.Now())
+		if len(keys) == 0 {
+			continue
+		}
+
+		logger.Info("processing pending bonuses", "count", len(keys))
+		for _, key := range keys {
+			if ctx.Err() != nil {
+				break
+			}
+			retryPendingBonus(key)
+		}
+	}
+}
+
+// closePendingBonuses rewrites both journals from the in-memory queues and
+// closes them, so a restart finds exactly what was queued at shutdown.
+func closePendingBonuses() {
+	pendingBonusesMu.Lock()
+	defer pendingBonusesMu.Unlock()
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+
+	if err := pendingJournal.close(pendingBonuses); err != nil {
+		logger.Error("failed to flush pending bonus queue", "error", err)
+	}
+	if err := deadLetterJournal.close(deadLetters); err != nil {
+		logger.Error("failed to flush dead letters", "error", err)
+	}
+	logger.Info("pending bonus queue flushed", "pending", len(pendingBonuses), "dead_letters", len(deadLetters))
+}
+
+func duePendingBonusKeys(now time.Time) []string {
+	pendingBonusesMu.RLock()
+	defer pendingBonusesMu.RUnlock()
+
+	keys := make([]string, 0, len(pendingBonuses))
+	for key, pending := range pendingBonuses {
+		if pending.Attempts == 0 || now.After(pending.LastAttempt.Add(pendingRetryPolicy.backoff(pending.Attempts))) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func retryPendingBonus(key string) error {
+	pendingBonusesMu.Lock()
+	pending, exists := pendingBonuses[key]
+	if !exists {
+		pendingBonusesMu.Unlock()
+		return errQueueEntryNotFound
+	}
+	if _, retrying := pendingAttempts[key]; retrying {
+		pendingBonusesMu.Unlock()
+		return errRetryInFlight
+	}
+	done := make(chan struct{})
+	pendingAttempts[key] = done
+	pending.Attempts++
+	pending.LastAttempt = time.Now()
+	retriesTotal.Inc(pendingRetryPolicy.Name)
+	attempt := *pending
+	if err := pendingJournal.put(key, attempt); err != nil {
+		logger.Error("failed to persist pending bonus attempt", "queue_key", key, "error", err)
+	}
+	pendingBonusesMu.Unlock()
+
+	ctx := platform.ContextWithTraceparent(context.Background(), attempt.Traceparent)
+	if attempt.RequestID != "" {
+		ctx = platform.ContextWithRequestID(ctx, attempt.RequestID)
+	}
+	ctx = platform.WithLogAttrs(ctx,
+		slog.String("queue_key", key),
+		slog.String("user", attempt.User),
+		slog.Int("attempt", attempt.Attempts))
+	ctx, span := platform.StartSpan(ctx, "retry_pending_bonus", platform.SpanKindInternal)
+	span.SetAttribute("queue.key", key)
+	span.SetAttribute("queue.attempt", attempt.Attempts)
+	err := registerBonus(ctx, attempt.User, attempt.Bonus, attempt.TransactionID, true)
+	span.End(err)
+
+	pendingBonusesMu.Lock()
+	defer pendingBonusesMu.Unlock()
+
+	delete(pendingAttempts, key)
+	close(done)
+	if _, exists := pendingBonuses[key]; !exists {
+		return err
+	}
+
+	if err == nil {
+		logger.InfoContext(ctx, "pending bonus registered")
+		delete(pendingBonuses, key)
+		if err := pendingJournal.delete(key); err != nil {
+			logger.Error("failed to persist pending bonus removal", "queue_key", key, "error", err)
+		}
+		return nil
+	}
+
+	logger.WarnContext(ctx, "pending bonus attempt failed", "error", err)
+	pending.LastError = err.Error()
+	// The open breaker rejected the attempt before it reached Fidelity, so
+	// it does not use one up; only MaxElapsed bounds a long outage.
+	if errors.Is(err, errCircuitOpen) {
+		pending.Attempts--
+	}
+
+	exhausted := pending.Attempts >= pendingRetryPolicy.MaxAttempts ||
+		(pendingRetryPolicy.MaxElapsed > 0 && time.Since(pending.retriedSince()) > pendingRetryPolicy.MaxElapsed)
+	if exhausted || (!pendingRetryPolicy.retryable(err) && !errors.Is(err, errCircuitOpen)) {
+		logger.ErrorContext(ctx, "giving up on pending bonus, moving it to dead letters")
+		delete(pendingBonuses, key)
+		addDeadLetter(key, *pending)
+		if err := pendingJournal.delete(key); err != nil {
+			logger.Error("failed to persist pending bonus removal", "queue_key", key, "error", err)
+		}
+		return err
+	}
+
+	if err := pendingJournal.put(key, *pending); err != nil {
+		logger.Error("failed to persist pending bonus attempt", "queue_key", key, "error", err)
+	}
+	return err
+}
+
+// retriedSince is when the current run of attempts began. Requeuing a dead
+// letter starts a new one, with a fresh MaxElapsed.
+func (p *PendingBonus) retriedSince() time.Time {
+	if !p.RequeuedAt.IsZero() {
+		return p.RequeuedAt
+	}
+	return p.CreatedAt
+}
+
+func addDeadLetter(key string, bonus PendingBonus) {
+	bonus.DeadAt = time.Now()
+
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+
+	deadLetters[key] = &bonus
+	if err := deadLetterJournal.put(key, bonus); err != nil {
+		logger.Error("failed to persist dead letter", "queue_key", key, "error", err)
+	}
+}
+
+// addLateDeadLetter records a bonus queued after closePendingBonuses, by a
+// purchase that outlived the shutdown drain. This process will not retry it,
+// so it goes to the dead letters on disk, where it can be requeued after the
+// restart.
+func addLateDeadLetter(key string, bonus PendingBonus) error {
+	bonus.DeadAt = time.Now()
+	bonus.LastError = "queued after shutdown"
+
+	deadLettersMu.Lock()
+	defer deadLettersMu.Unlock()
+
+	journal, entries, err := openBonusJournal(deadLetterJournal.path)
+	if err != nil {
+		return err
+	}
+	entries[key] = &bonus
+	deadLetters[key] = &bonus
+	return journal.close(entries)
+}
+
+type QueueEntry struct {
+	Key string `json:"key"`
+	PendingBonus
+}
+
+func listPendingBonuses() []QueueEntry {
+	pendingBonusesMu.RLock()
+	defer pendingBonusesMu.RUnlock()
+
+	return sortedQueueEntries(pendingBonuses)
+}
+
+func pendingBonusKeys() []string {
+	pendingBonusesMu.RLock()
+	defer pendingBonusesMu.RUnlock()
+
+	keys := make([]string, 0, len(pendingBonuses))
+	for key := range pendingBonuses {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func dropPendingBonus(key string) error {
+	pendingBonusesMu.Lock()
+	defer pendingBonusesMu.Unlock()
+
+	if _, exists := pendingBonuses[key]; !exists {
+		return errQueueEntryNotFound
+	}
+	if _, retrying := pendingAttempts[key]; retrying {
+		return errRetryInFlight
+	}
+	delete(pendingBonuses, key)
+	logger.Info("pending bonus dropped by admin", "queue_key", key)
+	return pendingJournal.delete(key)
+}
+
+// dropQueuedBonus removes the bonus of a cancelled purchase from the pending
+// queue and the dead letters, reporting whether it was in either. A retry
+// already sending the bonus may still land at Fidelity, so it waits for that
+// attempt to end; a bonus it registered is then gone from the queue and left
+// for the reversal that follows.
+func dropQueuedBonus(ctx context.Context, transactionID string) bool {
+	pendingBonusesMu.Lock()
+	for {
+		done, retrying := pendingAttempts[transactionID]
+		if !retrying {
+			break
+		}
+		pendingBonusesMu.Unlock()
+		logger.InfoContext(ctx, "waiting for the pending bonus retry in flight", "queue_key", transactionID)
+		<-done
+		pendingBonusesMu.Lock()
+	}
+	_, pending := pendingBonuses[transactionID]
+	if pending {
+		delete(pendingBonuses, transactionID)
+		if err := pendingJournal.delete(transactionID); err != nil {
+			logger.ErrorContext(ctx, "failed to persist pending bonus removal", "queue_key", transactionID, "error", err)
+		}
+	}
+	pendingBonusesMu.Unlock()
+
+	deadLettersMu.Lock()
+	_, dead := deadLetters[transactionID]
+	if dead {
+		delete(deadLetters, transactionID)
+		if err := deadLetterJournal.delete(transactionID); err != nil {
+			logger.ErrorContext(ctx, "failed to persist dead letter removal", "queue_key", transactionID, "error", err)
+		}
+	}
+	deadLettersMu.Unlock()
+
+	if pending || dead {
+		logger.InfoContext(ctx, "queued bonus dropped, purchase cancelled", "queue_key", transactionID)
+	}
+	return pending || dead
+}
+
+func listDeadLetters() []QueueEntry {
+	deadLettersMu.RLock()
+	defer deadLettersMu.RUnlock()
+
+	return sortedQueueEntries(deadLetters)
+}
+
+func deadLetterKeys() []string {
+	deadLettersMu.RLock()
+	defer deadLettersMu.RUnlock()
+
+	keys := make([]string, 0, len(deadLetters))
+	for key := range deadLetters {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func requeueDeadLetter(key string) error {
+	deadLettersMu.Lock()
+	dead, exists := deadLetters[key]
+	if !exists {
+		deadLettersMu.Unlock()
+		return errQueueEntryNotFound
+	}
+	delete(deadLetters, key)
+	err := deadLetterJournal.delete(key)
+	deadLettersMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	pending := *dead
+	pending.Attempts = 0
+	pending.DeadAt = time.Time{}
+	pending.RequeuedAt = time.Now()
+
+	pendingBonusesMu.Lock()
+	defer pendingBonusesMu.Unlock()
+
+	pendingBonuses[key] = &pending
+	logger.Info("dead letter requeued by admin", "queue_key", key)
+	return pendingJournal.put(key, pending)
+}
+
+func sortedQueueEntries(source map[string]*PendingBonus) []QueueEntry {
+	entries := make([]QueueEntry, 0, len(source))
+	for key, bonus := range source {
+		entries = append(entries, QueueEntry{Key: key, PendingBonus: *bonus})
+	}
+	slices.SortFunc(entries, func(a, b QueueEntry) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return entries
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestQueues points the pending queue and the dead letters at empty
+// journals in a temporary directory.
+func useTestQueues(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	journal, entries, err := openBonusJournal(filepath.Join(dir, "pending_bonuses.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadJournal, deadEntries, err := openBonusJournal(filepath.Join(dir, "dead_letters.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingBonusesMu.Lock()
+	pendingJournal, pendingBonuses = journal, entries
+	pendingBonusesMu.Unlock()
+	deadLettersMu.Lock()
+	deadLetterJournal, deadLetters = deadJournal, deadEntries
+	deadLettersMu.Unlock()
+
+	t.Cleanup(closePendingBonuses)
+}
+
+// useTestFidelity points fidelityURL at handler for the duration of the test.
+func useTestFidelity(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	previous := fidelityURL
+	fidelityURL = server.URL
+	t.Cleanup(func() {
+		fidelityURL = previous
+		server.Close()
+	})
+}
+
+func TestDropQueuedBonusWaitsForRetryInFlight(t *testing.T) {
+	useTestQueues(t)
+
+	received := make(chan struct{})
+	release := make(chan struct{})
+	useTestFidelity(t, func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	addPendingBonus(context.Background(), "u1", 500, "tx-1")
+
+	retried := make(chan error, 1)
+	go func() { retried <- retryPendingBonus("tx-1") }()
+	<-received
+
+	dropped := make(chan bool, 1)
+	go func() { dropped <- dropQueuedBonus(context.Background(), "tx-1") }()
+
+	select {
+	case <-dropped:
+		t.Fatal("bonus dropped while its retry was still being sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-retried; err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	// The retry registered the bonus, so it is for the reversal to undo.
+	if <-dropped {
+		t.Fatal("dropQueuedBonus reported a bonus that reached Fidelity as dropped")
+	}
+}
+
+func TestRetryPendingBonusRejectsConcurrentRetry(t *testing.T) {
+	useTestQueues(t)
+
+	received := make(chan struct{})
+	release := make(chan struct{})
+	useTestFidelity(t, func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	addPendingBonus(context.Background(), "u1", 500, "tx-1")
+	retried := make(chan error, 1)
+	go func() { retried <- retryPendingBonus("tx-1") }()
+	<-received
+
+	if err := retryPendingBonus("tx-1"); !errors.Is(err, errRetryInFlight) {
+		t.Fatalf("second retry: got %v, want %v", err, errRetryInFlight)
+	}
+	if err := dropPendingBonus("tx-1"); !errors.Is(err, errRetryInFlight) {
+		t.Fatalf("admin drop: got %v, want %v", err, errRetryInFlight)
+	}
+
+	close(release)
+	if err := <-retried; err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+}
+
+func TestRequeuedDeadLetterGetsFreshMaxElapsed(t *testing.T) {
+	useTestQueues(t)
+	useTestFidelity(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	previous := pendingRetryPolicy
+	pendingRetryPolicy.MaxElapsed = time.Hour
+	t.Cleanup(func() { pendingRetryPolicy = previous })
+
+	// The bonus died a day after the purchase, long past MaxElapsed.
+	addDeadLetter("tx-1", PendingBonus{User: "u1", Bonus: 500, TransactionID: "tx-1",
+		Attempts: pendingRetryPolicy.MaxAttempts, CreatedAt: time.Now().Add(-24 * time.Hour)})
+
+	if err := requeueDeadLetter("tx-1"); err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if err := retryPendingBonus("tx-1"); err == nil {
+		t.Fatal("retry against an unavailable Fidelity succeeded")
+	}
+
+	pendingBonusesMu.RLock()
+	pending, queued := pendingBonuses["tx-1"]
+	pendingBonusesMu.RUnlock()
+	if !queued {
+		t.Fatal("requeued bonus went straight back to dead letters after one failed attempt")
+	}
+	if pending.Attempts != 1 {
+		t.Fatalf("requeued bonus has %d attempts, want 1", pending.Attempts)
+	}
+}
+
+func TestPendingBonusSurvivesOpenBreaker(t *testing.T) {
+	useTestQueues(t)
+	previous := fidelityBreaker
+	fidelityBreaker = newTestBreaker()
+	fidelityBreaker.transition(breakerOpen)
+	t.Cleanup(func() { fidelityBreaker = previous })
+
+	addPendingBonus(context.Background(), "u1", 500, "tx-1")
+	for range pendingRetryPolicy.MaxAttempts + 1 {
+		if err := retryPendingBonus("tx-1"); !errors.Is(err, errCircuitOpen) {
+			t.Fatalf("got %v, want errCircuitOpen", err)
+		}
+	}
+
+	pending := listPendingBonuses()
+	if len(pending) != 1 || pending[0].Attempts != 0 {
+		t.Fatalf("pending %+v, want tx-1 still queued with no attempts used", pending)
+	}
+	if dead := listDeadLetters(); len(dead) != 0 {
+		t.Fatalf("dead letters %+v, want none", dead)
+	}
+}
+
+func TestBonusQueuedAfterShutdownKeptInDeadLetters(t *testing.T) {
+	useTestQueues(t)
+	closePendingBonuses()
+
+	addPendingBonus(context.Background(), "u1", 500, "tx-late")
+
+	journal, entries, err := openBonusJournal(deadLetterJournal.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.close(entries)
+	late, ok := entries["tx-late"]
+	if !ok || late.User != "u1" || late.Bonus != 500 || late.DeadAt.IsZero() {
+		t.Fatalf("dead letters on disk %v, want the late bonus of tx-late", entries)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"platform"
+)
+
+// PurchaseRecord is the outcome of one purchase saga, kept so sales and
+// bonuses can be audited against what the customer was told.
+type PurchaseRecord struct {
+	SagaID        string    `json:"saga_id"`
+	Reference     string    `json:"reference,omitempty"`
+	RequestID     string    `json:"request_id,omitempty"`
+	User          string    `json:"user"`
+	Flight        string    `json:"flight"`
+	Day           string    `json:"day"`
+	Cabin         string    `json:"cabin,omitempty"`
+	FT            bool      `json:"ft"`
+	Success       bool      `json:"success"`
+	Status        int       `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	TransactionID string    `json:"transaction_id,omitempty"`
+	ValueUSD      float64   `json:"value_usd,omitempty"`
+	ValueBRL      float64   `json:"value_brl,omitempty"`
+	ExchangeRate  float64   `json:"exchange_rate,omitempty"`
+	BonusPoints   int       `json:"bonus_points,omitempty"`
+	BonusStatus   string    `json:"bonus_status,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// Set once the customer cancelled the purchase.
+	Cancellation *CancellationReceipt `json:"cancellation,omitempty"`
+}
+
+// purchaseLog is an append-only log of purchase records. A saga written more
+// than once keeps its last record, and compaction drops the older ones.
+type purchaseLog struct {
+	log           *platform.AppendLog[PurchaseRecord]
+	records       []*PurchaseRecord
+	bySaga        map[string]*PurchaseRecord
+	byTransaction map[string]*PurchaseRecord
+	mu            sync.RWMutex
+}
+
+var purchases = newPurchaseLog()
+
+func newPurchaseLog() *purchaseLog {
+	return &purchaseLog{
+		bySaga:        make(map[string]*PurchaseRecord),
+		byTransaction: make(map[string]*PurchaseRecord),
+	}
+}
+
+func loadPurchases() error {
+	path := filepath.Join(dataDir, "purchases.log")
+	loaded := newPurchaseLog()
+	if err := platform.ReplayAppendLog(path, loaded.store); err != nil {
+		return err
+	}
+
+	log, err := platform.OpenAppendLog(path, loaded.snapshot())
+	if err != nil {
+		return err
+	}
+	loaded.log = log
+
+	purchases = loaded
+	logger.Info("restored purchase log", "purchases", len(loaded.records))
+	return nil
+}
+
+func (l *purchaseLog) store(record PurchaseRecord) {
+	stored, ok := l.bySaga[record.SagaID]
+	if ok {
+		*stored = record
+	} else {
+		stored = &record
+		l.records = append(l.records, stored)
+		l.bySaga[record.SagaID] = stored
+	}
+	if record.Success && record.TransactionID != "" {
+		l.byTransaction[record.TransactionID] = stored
+	}
+}
+
+// snapshot is the compacted log: the last record of every saga, in the
+// order the sagas started.
+func (l *purchaseLog) snapshot() []PurchaseRecord {
+	records := make([]PurchaseRecord, 0, len(l.records))
+	for _, record := range l.records {
+		records = append(records, *record)
+	}
+	return records
+}
+
+func (l *purchaseLog) put(record PurchaseRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.store(record)
+	if l.log == nil {
+		return platform.ErrLogClosed
+	}
+	return l.log.Append(record)
+}
+
+// completed returns the successful purchase of a transaction.
+func (l *purchaseLog) completed(transactionID string) (PurchaseRecord, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	record, ok := l.byTransaction[transactionID]
+	if !ok {
+		return PurchaseRecord{}, false
+	}
+	return *record, true
+}
+
+func (l *purchaseLog) list() []PurchaseRecord {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.snapshot()
+}
+
+// close compacts the log and closes it.
+func (l *purchaseLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.log == nil {
+		return nil
+	}
+	return l.log.Close(l.snapshot())
+}
+
+// recordPurchase logs the outcome of a purchase saga.
+func recordPurchase(ctx context.Context, saga *Saga, reference string, req BuyTicketRequest, status int, response BuyTicketResponse) {
+	record := PurchaseRecord{
+		SagaID:        saga.ID,
+		Reference:     reference,
+		RequestID:     platform.RequestIDFromContext(ctx),
+		User:          req.User,
+		Flight:        req.Flight,
+		Day:           req.Day,
+		Cabin:         cmp.Or(response.Cabin, req.Cabin),
+		FT:            req.FT,
+		Success:       response.Success,
+		Status:        status,
+		Error:         response.Error,
+		TransactionID: response.TransactionID,
+		ValueUSD:      response.ValueUSD,
+		ValueBRL:      response.ValueBRL,
+		ExchangeRate:  response.ExchangeRate,
+		BonusPoints:   response.BonusPoints,
+		BonusStatus:   response.BonusStatus,
+		CreatedAt:     time.Now(),
+	}
+	if err := purchases.put(record); err != nil {
+		logger.ErrorContext(ctx, "failed to persist purchase record", "error", err)
+	}
+}
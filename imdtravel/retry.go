@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"platform"
+)
+
+// RetryPolicy describes how an outbound call is retried. Transport errors are
+// always retryable; HTTP errors only when their status is in RetryableStatuses.
+type RetryPolicy struct {
+	Name              string
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	Multiplier        float64
+	Jitter            float64
+	MaxElapsed        time.Duration
+	RetryableStatuses []int
+}
+
+type retryPolicyConfig struct {
+	MaxAttempts       *int     `json:"max_attempts"`
+	InitialBackoff    *string  `json:"initial_backoff"`
+	MaxBackoff        *string  `json:"max_backoff"`
+	Multiplier        *float64 `json:"multiplier"`
+	Jitter            *float64 `json:"jitter"`
+	MaxElapsed        *string  `json:"max_elapsed"`
+	RetryableStatuses []int    `json:"retryable_statuses"`
+}
+
+var (
+	retryConfig = loadRetryConfigFile(os.Getenv("RETRY_CONFIG_FILE"))
+
+	flightRetryPolicy = loadRetryPolicy(RetryPolicy{
+		Name:           "flight",
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     500 * time.Millisecond,
+		Multiplier:     1,
+	})
+	exchangeRetryPolicy = loadRetryPolicy(RetryPolicy{
+		Name:              "exchange",
+		MaxAttempts:       1,
+		InitialBackoff:    100 * time.Millisecond,
+		MaxBackoff:        time.Second,
+		Multiplier:        2,
+		RetryableStatuses: []int{502, 503, 504},
+	})
+	sellRetryPolicy = loadRetryPolicy(RetryPolicy{
+		Name:              "sell",
+		MaxAttempts:       1,
+		InitialBackoff:    200 * time.Millisecond,
+		MaxBackoff:        time.Second,
+		Multiplier:        2,
+		RetryableStatuses: []int{502, 503, 504},
+	})
+	bonusRetryPolicy = loadRetryPolicy(RetryPolicy{
+		Name:              "bonus",
+		MaxAttempts:       3,
+		InitialBackoff:    100 * time.Millisecond,
+		MaxBackoff:        time.Second,
+		Multiplier:        2,
+		RetryableStatuses: []int{500, 502, 503, 504},
+	})
+	pendingRetryPolicy = loadRetryPolicy(RetryPolicy{
+		Name:              "pending",
+		MaxAttempts:       20,
+		InitialBackoff:    10 * time.Second,
+		MaxBackoff:        10 * time.Second,
+		Multiplier:        1,
+		RetryableStatuses: []int{500, 502, 503, 504},
+	})
+	compensationRetryPolicy = loadRetryPolicy(RetryPolicy{
+		Name:              "compensation",
+		MaxAttempts:       3,
+		InitialBackoff:    200 * time.Millisecond,
+		MaxBackoff:        2 * time.Second,
+		Multiplier:        2,
+		RetryableStatuses: []int{500, 502, 503, 504},
+	})
+)
+
+func loadRetryConfigFile(path string) map[string]retryPolicyConfig {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Warn("failed to read retry config", "path", path, "error", err)
+		return nil
+	}
+
+	var config map[string]retryPolicyConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		logger.Warn("failed to parse retry config", "path", path, "error", err)
+		return nil
+	}
+	return config
+}
+
+// loadRetryPolicy applies the config file entry for policy.Name and then the
+// <NAME>_RETRY_* environment variables on top of the given defaults.
+func loadRetryPolicy(policy RetryPolicy) RetryPolicy {
+	if config, ok := retryConfig[policy.Name]; ok {
+		if config.MaxAttempts != nil {
+			policy.MaxAttempts = *config.MaxAttempts
+		}
+		if config.InitialBackoff != nil {
+			policy.InitialBackoff = parseDuration(*config.InitialBackoff, policy.InitialBackoff)
+		}
+		if config.MaxBackoff != nil {
+			policy.MaxBackoff = parseDuration(*config.MaxBackoff, policy.MaxBackoff)
+		}
+		if config.Multiplier != nil {
+			policy.Multiplier = *config.Multiplier
+		}
+		if config.Jitter != nil {
+			policy.Jitter = *config.Jitter
+		}
+		if config.MaxElapsed != nil {
+			policy.MaxElapsed = parseDuration(*config.MaxElapsed, policy.MaxElapsed)
+		}
+		if config.RetryableStatuses != nil {
+			policy.RetryableStatuses = config.RetryableStatuses
+		}
+	}
+
+	prefix := strings.ToUpper(policy.Name) + "_RETRY_"
+	policy.MaxAttempts = max(getEnvInt(prefix+"MAX_ATTEMPTS", policy.MaxAttempts), 1)
+	policy.InitialBackoff = getEnvDuration(prefix+"INITIAL_BACKOFF", policy.InitialBackoff)
+	policy.MaxBackoff = getEnvDuration(prefix+"MAX_BACKOFF", policy.MaxBackoff)
+	policy.Multiplier = getEnvFloat(prefix+"MULTIPLIER", policy.Multiplier)
+	policy.Jitter = getEnvFloat(prefix+"JITTER", policy.Jitter)
+	policy.MaxElapsed = getEnvDuration(prefix+"MAX_ELAPSED", policy.MaxElapsed)
+	if statuses := os.Getenv(prefix + "STATUSES"); statuses != "" {
+		policy.RetryableStatuses = nil
+		for _, field := range strings.Split(statuses, ",") {
+			if code, err := strconv.Atoi(strings.TrimSpace(field)); err == nil {
+				policy.RetryableStatuses = append(policy.RetryableStatuses, code)
+			}
+		}
+	}
+
+	return policy
+}
+
+func parseDuration(value string, defaultValue time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	return defaultValue
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error, ctx is done,
+// or the policy runs out of attempts or elapsed time. fn receives the 1-based
+// attempt number and a ctx tagged with it, so the attempt's spans carry it.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context, attempt int) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(platform.ContextWithAttempt(ctx, p.Name, attempt), attempt)
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return err
+		}
+		retriesTotal.Inc(p.Name)
+		platform.AddSpanEvent(ctx, "retry", map[string]string{
+			"policy":  p.Name,
+			"attempt": strconv.Itoa(attempt + 1),
+			"delay":   delay.String(),
+			"error":   err.Error(),
+		})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, errCircuitOpen) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(p.RetryableStatuses, statusErr.Code)
+	}
+	return isTransportError(err)
+}
+
+// backoff returns the wait after the given failed attempt: exponential growth
+// from InitialBackoff capped at MaxBackoff, spread by ±Jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		delay = math.Min(delay, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
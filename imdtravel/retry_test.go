@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{
+		Name:              "test",
+		MaxAttempts:       3,
+		InitialBackoff:    time.Millisecond,
+		MaxBackoff:        time.Millisecond,
+		Multiplier:        1,
+		RetryableStatuses: []int{http.StatusServiceUnavailable},
+	}
+	transportError := &url.Error{Op: "Get", URL: "http://exchange/convert", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		wantErr  bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"recovers", []error{transportError, &StatusError{Code: http.StatusServiceUnavailable}, nil}, 3, false},
+		{"runs out of attempts", []error{transportError, transportError, transportError, nil}, 3, true},
+		{"status not retryable", []error{&StatusError{Code: http.StatusInternalServerError}, nil}, 1, true},
+		{"client error", []error{&StatusError{Code: http.StatusBadRequest}, nil}, 1, true},
+		{"circuit open", []error{errCircuitOpen, nil}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts []int
+			err := policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+				attempts = append(attempts, attempt)
+				return tt.errs[attempt-1]
+			})
+			if len(attempts) != tt.attempts {
+				t.Fatalf("%d attempts, want %d", len(attempts), tt.attempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyStopsAtMaxElapsed(t *testing.T) {
+	policy := RetryPolicy{
+		Name:           "test",
+		MaxAttempts:    10,
+		InitialBackoff: 20 * time.Millisecond,
+		Multiplier:     1,
+		MaxElapsed:     30 * time.Millisecond,
+	}
+	attempts := 0
+	policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+		attempts++
+		return &url.Error{Op: "Get", URL: "http://fidelity/bonus", Err: errors.New("connection refused")}
+	})
+	if attempts != 2 {
+		t.Fatalf("%d attempts, want 2 within MaxElapsed", attempts)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if got := policy.backoff(i + 1); got != w*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w*time.Millisecond)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("backoff with jitter %v outside 50ms..150ms", got)
+		}
+	}
+}
+
+func TestLoadRetryPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry.json")
+	config := `{"test": {"max_attempts": 5, "initial_backoff": "1s", "retryable_statuses": [502]}}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	previous := retryConfig
+	retryConfig = loadRetryConfigFile(path)
+	t.Cleanup(func() { retryConfig = previous })
+
+	// Environment variables win over the file, which wins over the defaults.
+	t.Setenv("TEST_RETRY_INITIAL_BACKOFF", "250ms")
+	t.Setenv("TEST_RETRY_STATUSES", "503, 504")
+
+	policy := loadRetryPolicy(RetryPolicy{
+		Name:           "test",
+		MaxAttempts:    1,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+	})
+	if policy.MaxAttempts != 5 {
+		t.Errorf("MaxAttempts = %d, want 5 from the file", policy.MaxAttempts)
+	}
+	if policy.InitialBackoff != 250*time.Millisecond {
+		t.Errorf("InitialBackoff = %v, want 250ms from the environment", policy.InitialBackoff)
+	}
+	if policy.MaxBackoff != 2*time.Second {
+		t.Errorf("MaxBackoff = %v, want the 2s default", policy.MaxBackoff)
+	}
+	if !slices.Equal(policy.RetryableStatuses, []int{503, 504}) {
+		t.Errorf("RetryableStatuses = %v, want [503 504] from the environment", policy.RetryableStatuses)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	stepDone               = "done"
+	stepFailed             = "failed"
+	stepCompensated        = "compensated"
+	stepCompensationFailed = "compensation_failed"
+)
+
+type SagaStep struct {
+	Name       string
+	Status     string
+	Error      string
+	At         time.Time
+	compensate func(ctx context.Context) error
+}
+
+// Saga tracks the steps of a single purchase and undoes the completed ones,
+// in reverse order, when a later step fails.
+type Saga struct {
+	ID    string
+	User  string
+	steps []*SagaStep
+	mu    sync.Mutex
+}
+
+func newSaga(user string) *Saga {
+	return &Saga{
+		ID:   rand.Text(),
+		User: user,
+	}
+}
+
+func (s *Saga) record(name string, compensate func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.steps = append(s.steps, &SagaStep{
+		Name:       name,
+		Status:     stepDone,
+		At:         time.Now(),
+		compensate: compensate,
+	})
+}
+
+// abort records the failed step and undoes the completed ones.
+func (s *Saga) abort(ctx context.Context, name string, cause error) error {
+	return s.abortUncertain(ctx, name, cause, nil)
+}
+
+// abortUncertain is abort for a step whose effect may have landed despite the
+// error, such as a request that timed out after reaching the service. Its
+// compensation runs first, before those of the completed steps.
+func (s *Saga) abortUncertain(ctx context.Context, name string, cause error, compensate func(ctx context.Context) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.steps = append(s.steps, &SagaStep{
+		Name:       name,
+		Status:     stepFailed,
+		Error:      cause.Error(),
+		At:         time.Now(),
+		compensate: compensate,
+	})
+	logger.WarnContext(ctx, "saga step failed, running compensations", "step", name, "error", cause)
+
+	var failed []string
+	for i := len(s.steps) - 1; i >= 0; i-- {
+		step := s.steps[i]
+		if step.compensate == nil || (step.Status != stepDone && step.Status != stepFailed) {
+			continue
+		}
+
+		if err := runCompensation(ctx, step.compensate); err != nil {
+			step.Status = stepCompensationFailed
+			step.Error = err.Error()
+			failed = append(failed, step.Name)
+			compensationsTotal.Inc(step.Name, "failed")
+			logger.ErrorContext(ctx, "saga compensation failed", "step", step.Name, "error", err)
+			continue
+		}
+
+		compensationsTotal.Inc(step.Name, "success")
+		step.Status = stepCompensated
+		logger.InfoContext(ctx, "saga step compensated", "step", step.Name)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("compensation failed for steps %v", failed)
+	}
+	return nil
+}
+
+// runCompensation retries compensate under compensationRetryPolicy. It does
+// not stop when ctx is cancelled: a compensation must finish even if the
+// caller has gone away.
+func runCompensation(ctx context.Context, compensate func(ctx context.Context) error) error {
+	return compensationRetryPolicy.Do(context.WithoutCancel(ctx), func(ctx context.Context, attempt int) error {
+		return compensate(ctx)
+	})
+}
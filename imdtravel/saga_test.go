@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// useFastCompensations shortens the compensation backoff for the test.
+func useFastCompensations(t *testing.T) {
+	t.Helper()
+
+	previous := compensationRetryPolicy
+	compensationRetryPolicy.InitialBackoff = time.Millisecond
+	t.Cleanup(func() { compensationRetryPolicy = previous })
+}
+
+func TestSagaAbortCompensatesInReverseOrder(t *testing.T) {
+	useFastCompensations(t)
+	var undone []string
+	attempts := 0
+	saga := newSaga("u1")
+	saga.record("get_flight", nil)
+	saga.record("sell_ticket", func(ctx context.Context) error {
+		undone = append(undone, "sell_ticket")
+		return nil
+	})
+	saga.record("reserve", func(ctx context.Context) error {
+		if attempts++; attempts == 1 {
+			return &StatusError{Code: http.StatusServiceUnavailable}
+		}
+		undone = append(undone, "reserve")
+		return nil
+	})
+
+	if err := saga.abort(context.Background(), "register_bonus", errors.New("timeout")); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if want := []string{"reserve", "sell_ticket"}; !slices.Equal(undone, want) {
+		t.Fatalf("compensated %v, want %v", undone, want)
+	}
+	if attempts != 2 {
+		t.Fatalf("compensation took %d attempts, want 2", attempts)
+	}
+}
+
+func TestSagaAbortReportsFailedCompensation(t *testing.T) {
+	useFastCompensations(t)
+	saga := newSaga("u1")
+	saga.record("sell_ticket", func(ctx context.Context) error { return errors.New("unavailable") })
+
+	if err := saga.abort(context.Background(), "register_bonus", errors.New("timeout")); err == nil {
+		t.Fatal("abort succeeded with a compensation that kept failing")
+	}
+	if step := saga.steps[0]; step.Status != stepCompensationFailed || step.Error != "unavailable" {
+		t.Fatalf("sell_ticket is %s (%q), want %s", step.Status, step.Error, stepCompensationFailed)
+	}
+}
+
+func TestSagaAbortUncertainCompensatesFailedStepFirst(t *testing.T) {
+	useFastCompensations(t)
+	var undone []string
+	attempts := 0
+	saga := newSaga("u1")
+	saga.record("hold_seat", func(ctx context.Context) error {
+		undone = append(undone, "hold_seat")
+		return nil
+	})
+	saga.record("confirm_sale", nil)
+
+	err := saga.abortUncertain(context.Background(), "register_bonus", context.DeadlineExceeded,
+		func(ctx context.Context) error {
+			if attempts++; attempts == 1 {
+				return &StatusError{Code: http.StatusServiceUnavailable}
+			}
+			undone = append(undone, "register_bonus")
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if want := []string{"register_bonus", "hold_seat"}; !slices.Equal(undone, want) {
+		t.Fatalf("compensated %v, want %v", undone, want)
+	}
+	if attempts != 2 {
+		t.Fatalf("bonus reversal took %d attempts, want 2", attempts)
+	}
+}
+
+func TestSagaCompensationOutlivesCaller(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	saga := newSaga("u1")
+	saga.record("sell_ticket", func(ctx context.Context) error { return ctx.Err() })
+	if err := saga.abort(ctx, "register_bonus", context.Canceled); err != nil {
+		t.Fatalf("compensation ran with the caller's cancelled context: %v", err)
+	}
+}
+
+func TestReverseRegisteredBonus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"bonus reversed", http.StatusOK, false},
+		{"bonus never registered", http.StatusNotFound, false},
+		{"fidelity down", http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestFidelity(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/bonus/reverse" {
+					t.Errorf("request to %s, want /bonus/reverse", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+			})
+			if err := reverseRegisteredBonus(context.Background(), "tx-1"); (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfirmSaleOfUnknownHold(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"Hold not found"}`, http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	previous := airlinesHubURL
+	airlinesHubURL = server.URL
+	t.Cleanup(func() { airlinesHubURL = previous })
+
+	for _, ft := range []bool{false, true} {
+		if _, err := confirmSale(context.Background(), "hold-1", ft); !errors.Is(err, errHoldExpired) {
+			t.Fatalf("ft=%t: got %v, want errHoldExpired", ft, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"platform"
+)
+
+// /searchFlights forwards a search to AirlinesHub's /flights and prices the
+// results in BRL with one exchange rate. ft=true gives the search the same
+// protections as the flight lookup and exchange steps of a purchase.
+
+type FlightOffer struct {
+	Flight      string         `json:"flight"`
+	Day         string         `json:"day"`
+	Airline     string         `json:"airline"`
+	Origin      string         `json:"origin"`
+	Destination string         `json:"destination"`
+	Departure   time.Time      `json:"departure"`
+	Arrival     time.Time      `json:"arrival"`
+	ValueUSD    float64        `json:"value_usd"`
+	ValueBRL    float64        `json:"value_brl"`
+	Available   map[string]int `json:"available"`
+}
+
+type FlightSearchResponse struct {
+	Flights      []FlightOffer `json:"flights"`
+	ExchangeRate float64       `json:"exchange_rate"`
+	Total        int           `json:"total"`
+	Page         int           `json:"page"`
+	PageSize     int           `json:"page_size"`
+}
+
+// flightSearchResult is AirlinesHub's reply to /flights.
+type flightSearchResult struct {
+	Flights []struct {
+		Flight      string         `json:"flight"`
+		Day         string         `json:"day"`
+		Airline     string         `json:"airline"`
+		Origin      string         `json:"origin"`
+		Destination string         `json:"destination"`
+		Departure   time.Time      `json:"departure"`
+		Arrival     time.Time      `json:"arrival"`
+		Value       float64        `json:"value"`
+		Available   map[string]int `json:"available"`
+	} `json:"flights"`
+	Total    int `json:"total"`
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+}
+
+func searchFlightsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	ft, _ := strconv.ParseBool(query.Get("ft"))
+	query.Del("ft")
+
+	ctx, cancel := context.WithTimeout(r.Context(), purchaseBudget)
+	defer cancel()
+
+	ctx, span := platform.StartSpan(ctx, "search_flights", platform.SpanKindInternal)
+	result, err := searchFlights(ctx, query.Encode(), ft)
+	span.End(err)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusBadRequest {
+		respondError(w, remoteErrorMessage(statusErr), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to search flights", "error", err)
+		respondError(w, fmt.Sprintf("Failed to search flights: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, span = platform.StartSpan(ctx, "get_exchange_rate", platform.SpanKindInternal)
+	rate, err := getExchangeRate(ctx, ft)
+	span.End(err)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to get exchange rate", "error", err)
+		respondError(w, fmt.Sprintf("Failed to get exchange rate: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	response := FlightSearchResponse{
+		Flights:      make([]FlightOffer, 0, len(result.Flights)),
+		ExchangeRate: rate,
+		Total:        result.Total,
+		Page:         result.Page,
+		PageSize:     result.PageSize,
+	}
+	for _, flight := range result.Flights {
+		response.Flights = append(response.Flights, FlightOffer{
+			Flight:      flight.Flight,
+			Day:         flight.Day,
+			Airline:     flight.Airline,
+			Origin:      flight.Origin,
+			Destination: flight.Destination,
+			Departure:   flight.Departure,
+			Arrival:     flight.Arrival,
+			ValueUSD:    flight.Value,
+			ValueBRL:    math.Round(flight.Value*rate*100) / 100,
+			Available:   flight.Available,
+		})
+	}
+	respondJSON(w, response, http.StatusOK)
+}
+
+func searchFlights(ctx context.Context, rawQuery string, ft bool) (flightSearchResult, error) {
+	url := fmt.Sprintf("%s/flights?%s", airlinesHubURL, rawQuery)
+
+	fetch := func(ctx context.Context) (flightSearchResult, error) {
+		ctx, cancel := context.WithTimeout(ctx, flightTimeout)
+		defer cancel()
+
+		var result flightSearchResult
+		err := fetchJSON(ctx, url, &result)
+		return result, err
+	}
+
+	if !ft {
+		return fetch(ctx)
+	}
+
+	var result flightSearchResult
+	err := flightRetryPolicy.Do(ctx, func(ctx context.Context, attempt int) error {
+		return airlinesHubBreaker.execute(ctx, func() error {
+			var err error
+			result, err = fetch(ctx)
+			return err
+		})
+	})
+	if errors.Is(err, errCircuitOpen) {
+		return result, fmt.Errorf("o serviço de voos está temporariamente indisponível: %w", err)
+	}
+	return result, err
+}
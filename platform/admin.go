@@ -0,0 +1,35 @@
+package platform
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// AdminOnly guards an endpoint that changes the state of the service. Only
+// requests carrying the ADMIN_TOKEN of the service as a bearer token reach
+// handler; without ADMIN_TOKEN the endpoint is disabled. Requests with one
+// of the open methods, such as GET for a listing, always pass.
+func AdminOnly(handler http.HandlerFunc, open ...string) http.HandlerFunc {
+	token := os.Getenv("ADMIN_TOKEN")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(open, r.Method) {
+			handler(w, r)
+			return
+		}
+		if token == "" {
+			respondError(w, "Admin endpoints are disabled: ADMIN_TOKEN is not set", http.StatusForbidden)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondError(w, "Missing or invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
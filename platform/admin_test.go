@@ -0,0 +1,42 @@
+package platform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminOnly(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	tests := []struct {
+		name          string
+		token         string
+		method        string
+		authorization string
+		want          int
+	}{
+		{"open method without token", "", http.MethodGet, "", http.StatusNoContent},
+		{"disabled without ADMIN_TOKEN", "", http.MethodPut, "Bearer secret", http.StatusForbidden},
+		{"missing token", "secret", http.MethodPut, "", http.StatusUnauthorized},
+		{"wrong token", "secret", http.MethodPut, "Bearer other", http.StatusUnauthorized},
+		{"not a bearer token", "secret", http.MethodPut, "secret", http.StatusUnauthorized},
+		{"admin token", "secret", http.MethodPut, "Bearer secret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ADMIN_TOKEN", tt.token)
+			handler := AdminOnly(ok, http.MethodGet)
+
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
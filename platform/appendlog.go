@@ -0,0 +1,164 @@
+package platform
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrLogClosed is returned by appends to an AppendLog after Close.
+var ErrLogClosed = errors.New("log is closed")
+
+// AppendLog is a file of JSON records, one per line, that is only appended
+// to and fsynced after every record. Its owner rebuilds its state with
+// ReplayAppendLog, and the file is compacted to the records that state still
+// needs when it is opened and when it is closed.
+type AppendLog[R any] struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+// ReplayAppendLog calls apply with every record of the log at path, in the
+// order they were written. A missing file has no records. Only the last line
+// may be cut short, by a crash in the middle of a write; it is dropped, and
+// OpenAppendLog compacts the log without it. A corrupt line anywhere else is
+// an error, so records are never lost silently.
+func ReplayAppendLog[R any](path string, apply func(R)) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) > 0 {
+				logger.Warn("dropping truncated last log record", "path", path, "line", line)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		var record R
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("corrupt record at %s:%d: %w", path, line, err)
+		}
+		apply(record)
+	}
+}
+
+// OpenAppendLog compacts the log at path down to records and opens it for
+// appending.
+func OpenAppendLog[R any](path string, records []R) (*AppendLog[R], error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := compactAppendLog(path, records); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &AppendLog[R]{path: path, file: file}, nil
+}
+
+// compactAppendLog replaces the log at path with records. The new file is
+// written aside and renamed over the old one, so a crash leaves either.
+func compactAppendLog[R any](path string, records []R) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted %s: %w", path, err)
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write compacted %s: %w", path, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write compacted %s: %w", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync compacted %s: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close compacted %s: %w", path, err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Append writes record at the end of the log. When the write fails the log
+// is truncated back, so a partial record never sits before later ones.
+func (l *AppendLog[R]) Append(record R) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", l.path, err)
+	}
+	if _, err := l.file.Write(data); err != nil {
+		return l.rollback(info.Size(), fmt.Errorf("failed to write record: %w", err))
+	}
+	if err := l.file.Sync(); err != nil {
+		return l.rollback(info.Size(), fmt.Errorf("failed to sync record: %w", err))
+	}
+	return nil
+}
+
+// rollback truncates the log back to size after a failed append. Callers
+// hold l.mu.
+func (l *AppendLog[R]) rollback(size int64, err error) error {
+	if truncateErr := l.file.Truncate(size); truncateErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to truncate %s: %w", l.path, truncateErr))
+	}
+	return err
+}
+
+// Close compacts the log down to records and closes it. Later appends fail
+// with ErrLogClosed.
+func (l *AppendLog[R]) Close(records []R) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close %s: %w", l.path, err)
+	}
+	return compactAppendLog(l.path, records)
+}
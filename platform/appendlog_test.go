@@ -0,0 +1,81 @@
+package platform
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+type testRecord struct {
+	ID string `json:"id"`
+}
+
+func replayIDs(t *testing.T, path string) []string {
+	t.Helper()
+
+	var ids []string
+	if err := ReplayAppendLog(path, func(record testRecord) { ids = append(ids, record.ID) }); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestAppendLogCompactsOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.log")
+	log, err := OpenAppendLog(path, []testRecord{{ID: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b", "c"} {
+		if err := log.Append(testRecord{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := replayIDs(t, path); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("replayed %v before close", got)
+	}
+
+	if err := log.Close([]testRecord{{ID: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := replayIDs(t, path); !slices.Equal(got, []string{"c"}) {
+		t.Fatalf("replayed %v after close, want the compacted records", got)
+	}
+	if err := log.Append(testRecord{ID: "d"}); !errors.Is(err, ErrLogClosed) {
+		t.Fatalf("append after close: %v, want ErrLogClosed", err)
+	}
+}
+
+func TestReplayAppendLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{"missing file", "", nil, false},
+		{"truncated last line", "{\"id\":\"a\"}\n{\"id\":", []string{"a"}, false},
+		{"corrupt line", "{\"id\":\"a\"}\nnot json\n{\"id\":\"b\"}\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "records.log")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			err := ReplayAppendLog(path, func(record testRecord) { got = append(got, record.ID) })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Fatalf("replayed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+// Package platform is the service infrastructure shared by IMDTravel,
+// AirlinesHub, Exchange and Fidelity: structured logs with request IDs,
+// Prometheus metrics, W3C tracing of incoming and outgoing requests, health
+// probes, graceful shutdown and simulated faults. Each service declares its
+// own faults, metrics and readiness dependencies.
+package platform
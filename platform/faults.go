@@ -0,0 +1,564 @@
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Simulated faults can be tuned at runtime through /faults. Their defaults
+// come from the code, then FAULTS_CONFIG_FILE, then <NAME>_FAULT_* variables.
+//
+// Every fault draws from its own generator seeded from FAULTS_SEED, so the
+// same sequence of requests meets the same faults. A schedule, loaded from
+// FAULTS_SCHEDULE_FILE or PUT /faults/schedule, replaces the probabilities
+// with explicit request or time windows.
+
+const (
+	FaultOmission = "omission"
+	FaultLatency  = "latency"
+	FaultError    = "error"
+	FaultCrash    = "crash"
+)
+
+var errFaultNotFound = errors.New("fault not found")
+
+// Fault fires with Probability on each request to Endpoint. Once fired it
+// stays active for Duration, affecting every request in that window.
+type Fault struct {
+	Name        string
+	Endpoint    string
+	Type        string
+	Enabled     bool
+	Probability float64
+	Duration    time.Duration
+	Delay       time.Duration
+	Status      int
+
+	activeUntil time.Time
+}
+
+type FaultStatus struct {
+	Name        string    `json:"name"`
+	Endpoint    string    `json:"endpoint"`
+	Type        string    `json:"type"`
+	Enabled     bool      `json:"enabled"`
+	Probability float64   `json:"probability"`
+	Duration    string    `json:"duration"`
+	Delay       string    `json:"delay,omitempty"`
+	Status      int       `json:"status,omitempty"`
+	ActiveUntil time.Time `json:"active_until,omitzero"`
+}
+
+type faultConfig struct {
+	Enabled     *bool    `json:"enabled"`
+	Probability *float64 `json:"probability"`
+	Duration    *string  `json:"duration"`
+	Delay       *string  `json:"delay"`
+	Status      *int     `json:"status"`
+}
+
+// FaultSchedule fires faults only inside the windows of its rules. Faults
+// without a rule stay quiet while the schedule is loaded. Its Seed, if any,
+// replaces FAULTS_SEED only while the schedule is loaded.
+type FaultSchedule struct {
+	Seed  *uint64     `json:"seed,omitempty"`
+	Rules []FaultRule `json:"rules"`
+}
+
+// FaultRule matches the From-th to To-th requests that reach the fault
+// (1-based, inclusive) and/or the time window after the schedule started.
+type FaultRule struct {
+	Fault       string         `json:"fault"`
+	Requests    *requestWindow `json:"requests,omitempty"`
+	Time        *timeWindow    `json:"time,omitempty"`
+	Probability *float64       `json:"probability,omitempty"`
+	Delay       *string        `json:"delay,omitempty"`
+	Status      *int           `json:"status,omitempty"`
+}
+
+type requestWindow struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+type timeWindow struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type ScheduleStatus struct {
+	Seed      uint64         `json:"seed"`
+	StartedAt time.Time      `json:"started_at,omitzero"`
+	Schedule  *FaultSchedule `json:"schedule"`
+	Requests  map[string]int `json:"requests"`
+}
+
+// FaultInjector decides which requests meet the faults of a service.
+type FaultInjector struct {
+	mu       sync.Mutex
+	names    []string
+	defaults map[string]Fault
+	faults   map[string]*Fault
+
+	configuredSeed uint64
+	rngs           map[string]*rand.Rand
+	requests       map[string]int
+	schedule       *FaultSchedule
+	scheduleStart  time.Time
+}
+
+func NewFaultInjector(defaults ...Fault) *FaultInjector {
+	fileConfig := loadFaultConfigFile(os.Getenv("FAULTS_CONFIG_FILE"))
+
+	fi := &FaultInjector{
+		defaults:       make(map[string]Fault),
+		faults:         make(map[string]*Fault),
+		configuredSeed: rand.Uint64(),
+	}
+	if seed, err := strconv.ParseUint(os.Getenv("FAULTS_SEED"), 10, 64); err == nil {
+		fi.configuredSeed = seed
+	}
+	for _, fault := range defaults {
+		if config, ok := fileConfig[fault.Name]; ok {
+			if err := fault.apply(config); err != nil {
+				logger.Warn("invalid fault config", "name", fault.Name, "error", err)
+			}
+		}
+		if err := fault.apply(faultConfigFromEnv(fault.Name)); err != nil {
+			logger.Warn("invalid fault environment", "name", fault.Name, "error", err)
+		}
+
+		fi.names = append(fi.names, fault.Name)
+		fi.defaults[fault.Name] = fault
+		current := fault
+		fi.faults[fault.Name] = &current
+	}
+
+	if path := os.Getenv("FAULTS_SCHEDULE_FILE"); path != "" {
+		if err := fi.loadScheduleFile(path); err != nil {
+			logger.Warn("failed to load fault schedule", "path", path, "error", err)
+		}
+	}
+	fi.restart()
+	logger.Info("fault injection configured", "seed", fi.seed(), "scheduled", fi.schedule != nil)
+	return fi
+}
+
+func (fi *FaultInjector) loadScheduleFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var schedule FaultSchedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return err
+	}
+	if err := fi.validateSchedule(&schedule); err != nil {
+		return err
+	}
+	fi.schedule = &schedule
+	return nil
+}
+
+func (fi *FaultInjector) validateSchedule(schedule *FaultSchedule) error {
+	for i, rule := range schedule.Rules {
+		if _, exists := fi.faults[rule.Fault]; !exists {
+			return fmt.Errorf("rule %d: unknown fault %q", i+1, rule.Fault)
+		}
+		if rule.Requests == nil && rule.Time == nil {
+			return fmt.Errorf("rule %d: needs a requests or time window", i+1)
+		}
+		if w := rule.Requests; w != nil && (w.From < 1 || w.To < w.From) {
+			return fmt.Errorf("rule %d: invalid requests window %d-%d", i+1, w.From, w.To)
+		}
+		if w := rule.Time; w != nil {
+			from, err := time.ParseDuration(w.From)
+			if err != nil {
+				return fmt.Errorf("rule %d: invalid time window start %q", i+1, w.From)
+			}
+			to, err := time.ParseDuration(w.To)
+			if err != nil || to <= from {
+				return fmt.Errorf("rule %d: invalid time window end %q", i+1, w.To)
+			}
+		}
+		if p := rule.Probability; p != nil && (*p < 0 || *p > 1) {
+			return fmt.Errorf("rule %d: probability must be between 0 and 1", i+1)
+		}
+
+		fault := *fi.faults[rule.Fault]
+		if err := fault.apply(faultConfig{Delay: rule.Delay, Status: rule.Status}); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// seed is the seed of the loaded schedule, or FAULTS_SEED when there is none
+// or it does not set one.
+func (fi *FaultInjector) seed() uint64 {
+	if fi.schedule != nil && fi.schedule.Seed != nil {
+		return *fi.schedule.Seed
+	}
+	return fi.configuredSeed
+}
+
+// restart reseeds every generator, zeroes the request counters and restarts
+// the schedule clock, so a run can be repeated without restarting the service.
+func (fi *FaultInjector) restart() {
+	fi.rngs = make(map[string]*rand.Rand)
+	fi.requests = make(map[string]int)
+	for _, name := range fi.names {
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+		fi.rngs[name] = rand.New(rand.NewPCG(fi.seed(), hash.Sum64()))
+		fi.faults[name].activeUntil = time.Time{}
+	}
+	fi.scheduleStart = time.Now()
+}
+
+func loadFaultConfigFile(path string) map[string]faultConfig {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logger.Warn("failed to read fault config", "path", path, "error", err)
+		return nil
+	}
+
+	var config map[string]faultConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		logger.Warn("failed to parse fault config", "path", path, "error", err)
+		return nil
+	}
+	return config
+}
+
+func faultConfigFromEnv(name string) faultConfig {
+	prefix := strings.ToUpper(name) + "_FAULT_"
+
+	var config faultConfig
+	if value, err := strconv.ParseBool(os.Getenv(prefix + "ENABLED")); err == nil {
+		config.Enabled = &value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv(prefix+"PROBABILITY"), 64); err == nil {
+		config.Probability = &value
+	}
+	if value := os.Getenv(prefix + "DURATION"); value != "" {
+		config.Duration = &value
+	}
+	if value := os.Getenv(prefix + "DELAY"); value != "" {
+		config.Delay = &value
+	}
+	if value, err := strconv.Atoi(os.Getenv(prefix + "STATUS")); err == nil {
+		config.Status = &value
+	}
+	return config
+}
+
+// apply validates config and sets the fields it names. Nothing changes when
+// any of them is invalid.
+func (f *Fault) apply(config faultConfig) error {
+	updated := *f
+	if config.Enabled != nil {
+		updated.Enabled = *config.Enabled
+	}
+	if config.Probability != nil {
+		if *config.Probability < 0 || *config.Probability > 1 {
+			return fmt.Errorf("probability must be between 0 and 1")
+		}
+		updated.Probability = *config.Probability
+	}
+	if config.Duration != nil {
+		d, err := time.ParseDuration(*config.Duration)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid duration %q", *config.Duration)
+		}
+		updated.Duration = d
+	}
+	if config.Delay != nil {
+		d, err := time.ParseDuration(*config.Delay)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid delay %q", *config.Delay)
+		}
+		updated.Delay = d
+	}
+	if config.Status != nil {
+		if *config.Status < 400 || *config.Status > 599 {
+			return fmt.Errorf("status must be a 4xx or 5xx code")
+		}
+		updated.Status = *config.Status
+	}
+
+	updated.activeUntil = time.Time{}
+	*f = updated
+	return nil
+}
+
+func (f *Fault) status() FaultStatus {
+	status := FaultStatus{
+		Name:        f.Name,
+		Endpoint:    f.Endpoint,
+		Type:        f.Type,
+		Enabled:     f.Enabled,
+		Probability: f.Probability,
+		Duration:    f.Duration.String(),
+		Status:      f.Status,
+	}
+	if f.Type == FaultLatency {
+		status.Delay = f.Delay.String()
+	}
+	if time.Now().Before(f.activeUntil) {
+		status.ActiveUntil = f.activeUntil
+	}
+	return status
+}
+
+// Trigger reports whether the named fault affects the current request and
+// counts it in faults_injected_total when it does.
+func (fi *FaultInjector) Trigger(name string) (Fault, bool) {
+	fault, triggered := fi.trigger(name)
+	if triggered {
+		faultsInjectedTotal.Inc(fault.Type)
+	}
+	return fault, triggered
+}
+
+func (fi *FaultInjector) trigger(name string) (Fault, bool) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	fault, exists := fi.faults[name]
+	if !exists || !fault.Enabled {
+		return Fault{}, false
+	}
+	fi.requests[name]++
+
+	now := time.Now()
+	if fi.schedule != nil {
+		return fi.scheduled(fault, fi.requests[name], now.Sub(fi.scheduleStart))
+	}
+
+	if now.Before(fault.activeUntil) {
+		return *fault, true
+	}
+	if fi.rngs[name].Float64() >= fault.Probability {
+		return Fault{}, false
+	}
+	fault.activeUntil = now.Add(fault.Duration)
+	return *fault, true
+}
+
+// scheduled applies the first rule for fault whose windows contain the n-th
+// request at elapsed. Windows were validated when the schedule was loaded.
+func (fi *FaultInjector) scheduled(fault *Fault, n int, elapsed time.Duration) (Fault, bool) {
+	for _, rule := range fi.schedule.Rules {
+		if rule.Fault != fault.Name {
+			continue
+		}
+		if w := rule.Requests; w != nil && (n < w.From || n > w.To) {
+			continue
+		}
+		if w := rule.Time; w != nil {
+			from, _ := time.ParseDuration(w.From)
+			to, _ := time.ParseDuration(w.To)
+			if elapsed < from || elapsed >= to {
+				continue
+			}
+		}
+		if rule.Probability != nil && fi.rngs[fault.Name].Float64() >= *rule.Probability {
+			continue
+		}
+
+		triggered := *fault
+		triggered.apply(faultConfig{Delay: rule.Delay, Status: rule.Status})
+		return triggered, true
+	}
+	return Fault{}, false
+}
+
+func (fi *FaultInjector) list() []FaultStatus {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	statuses := make([]FaultStatus, 0, len(fi.names))
+	for _, name := range fi.names {
+		statuses = append(statuses, fi.faults[name].status())
+	}
+	return statuses
+}
+
+func (fi *FaultInjector) update(name string, config faultConfig) (FaultStatus, error) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	fault, exists := fi.faults[name]
+	if !exists {
+		return FaultStatus{}, errFaultNotFound
+	}
+	if err := fault.apply(config); err != nil {
+		return FaultStatus{}, err
+	}
+	return fault.status(), nil
+}
+
+func (fi *FaultInjector) reset(name string) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if name == "" {
+		for _, name := range fi.names {
+			*fi.faults[name] = fi.defaults[name]
+		}
+		fi.restart()
+		return nil
+	}
+
+	if _, exists := fi.faults[name]; !exists {
+		return errFaultNotFound
+	}
+	*fi.faults[name] = fi.defaults[name]
+	return nil
+}
+
+func (fi *FaultInjector) scheduleStatus() ScheduleStatus {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	status := ScheduleStatus{
+		Seed:     fi.seed(),
+		Schedule: fi.schedule,
+		Requests: maps.Clone(fi.requests),
+	}
+	if fi.schedule != nil {
+		status.StartedAt = fi.scheduleStart
+	}
+	return status
+}
+
+// setSchedule replaces the schedule (nil clears it) and restarts the run.
+func (fi *FaultInjector) setSchedule(schedule *FaultSchedule) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if schedule != nil {
+		if err := fi.validateSchedule(schedule); err != nil {
+			return err
+		}
+	}
+	fi.schedule = schedule
+	fi.restart()
+	return nil
+}
+
+// HandleFaults registers the /faults API of fi. Reading the faults and the
+// schedule is open; changing them needs the admin token (see AdminOnly).
+func (fi *FaultInjector) HandleFaults() {
+	http.HandleFunc("/faults", AdminOnly(fi.FaultsHandler, http.MethodGet))
+	http.HandleFunc("/faults/reset", AdminOnly(fi.ResetHandler))
+	http.HandleFunc("/faults/schedule", AdminOnly(fi.ScheduleHandler, http.MethodGet))
+}
+
+// FaultsHandler lists the faults on GET and, on PUT ?name=, changes the
+// fields present in the JSON body.
+func (fi *FaultInjector) FaultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respondFaultJSON(w, fi.list(), http.StatusOK)
+	case http.MethodPut:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			respondError(w, "Missing required parameter: name", http.StatusBadRequest)
+			return
+		}
+
+		var config faultConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		status, err := fi.update(name, config)
+		if errors.Is(err, errFaultNotFound) {
+			respondError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.InfoContext(r.Context(), "fault updated", "name", name, "enabled", status.Enabled, "probability", status.Probability)
+		respondFaultJSON(w, status, http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ResetHandler restores the startup configuration of one fault, or of
+// all of them when no name is given. A full reset also restarts the run:
+// generators are reseeded and request counters go back to zero.
+func (fi *FaultInjector) ResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if err := fi.reset(name); err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logger.InfoContext(r.Context(), "faults reset", "name", name)
+	respondFaultJSON(w, fi.list(), http.StatusOK)
+}
+
+// ScheduleHandler shows, replaces (PUT) or clears (DELETE) the schedule.
+// Replacing or clearing it restarts the run.
+func (fi *FaultInjector) ScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var schedule FaultSchedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			respondError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := fi.setSchedule(&schedule); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.InfoContext(r.Context(), "fault schedule loaded", "rules", len(schedule.Rules))
+	case http.MethodDelete:
+		fi.setSchedule(nil)
+		logger.InfoContext(r.Context(), "fault schedule cleared")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondFaultJSON(w, fi.scheduleStatus(), http.StatusOK)
+}
+
+func respondFaultJSON(w http.ResponseWriter, response any, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func respondError(w http.ResponseWriter, message string, statusCode int) {
+	respondFaultJSON(w, map[string]string{"error": message}, statusCode)
+}
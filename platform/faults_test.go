@@ -0,0 +1,123 @@
+package platform
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFault is the code default the tests start from.
+var testFault = Fault{Name: "test", Endpoint: "/test", Type: FaultError, Probability: 0.1, Duration: time.Second, Status: 500}
+
+// newTestInjector builds an injector for testFault without any fault
+// configuration from the environment.
+func newTestInjector(t *testing.T) *FaultInjector {
+	t.Helper()
+
+	for _, key := range []string{"FAULTS_CONFIG_FILE", "FAULTS_SCHEDULE_FILE", "TEST_FAULT_ENABLED", "TEST_FAULT_PROBABILITY", "TEST_FAULT_STATUS"} {
+		t.Setenv(key, "")
+	}
+	return NewFaultInjector(testFault)
+}
+
+func TestFaultDefaults(t *testing.T) {
+	newTestInjector(t)
+	path := filepath.Join(t.TempDir(), "faults.json")
+	if err := os.WriteFile(path, []byte(`{"test": {"enabled": true, "probability": 0.5, "status": 502}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAULTS_CONFIG_FILE", path)
+	// The environment wins over the file, which wins over the code.
+	t.Setenv("TEST_FAULT_PROBABILITY", "0.25")
+
+	got := NewFaultInjector(testFault).list()[0]
+	if !got.Enabled || got.Probability != 0.25 || got.Status != 502 {
+		t.Fatalf("got enabled %t, probability %v, status %d; want true, 0.25, 502", got.Enabled, got.Probability, got.Status)
+	}
+}
+
+func TestFaultsHandler(t *testing.T) {
+	fi := newTestInjector(t)
+	put := func(name, body string) (*httptest.ResponseRecorder, FaultStatus) {
+		w := httptest.NewRecorder()
+		fi.FaultsHandler(w, httptest.NewRequest(http.MethodPut, "/faults?name="+name, strings.NewReader(body)))
+		var status FaultStatus
+		json.NewDecoder(w.Body).Decode(&status)
+		return w, status
+	}
+
+	w, status := put("test", `{"enabled": true, "probability": 1, "duration": "5s"}`)
+	if w.Code != http.StatusOK || !status.Enabled || status.Probability != 1 || status.Duration != "5s" || status.Status != 500 {
+		t.Fatalf("update: %d %+v", w.Code, status)
+	}
+	if w, _ := put("test", `{"probability": 2, "enabled": false}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid probability: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if got := fi.list()[0]; !got.Enabled || got.Probability != 1 {
+		t.Fatalf("invalid update changed the fault: %+v", got)
+	}
+	if w, _ := put("missing", `{"enabled": true}`); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown fault: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = httptest.NewRecorder()
+	fi.ResetHandler(w, httptest.NewRequest(http.MethodPost, "/faults/reset", nil))
+	if got := fi.list()[0]; w.Code != http.StatusOK || got.Enabled || got.Probability != 0.1 || got.Duration != "1s" {
+		t.Fatalf("reset: %d %+v, want the startup configuration", w.Code, got)
+	}
+}
+
+func draws(fi *FaultInjector, n int) []bool {
+	var triggered []bool
+	for range n {
+		_, ok := fi.Trigger("flaky")
+		triggered = append(triggered, ok)
+	}
+	return triggered
+}
+
+func TestScheduleSeedOnlyAppliesWhileLoaded(t *testing.T) {
+	t.Setenv("FAULTS_SEED", "7")
+	fi := NewFaultInjector(Fault{Name: "flaky", Type: FaultError, Enabled: true, Probability: 0.5, Status: 500})
+	configured := draws(fi, 50)
+
+	scheduleSeed := uint64(42)
+	seeded := &FaultSchedule{
+		Seed:  &scheduleSeed,
+		Rules: []FaultRule{{Fault: "flaky", Requests: &requestWindow{From: 1, To: 1000}}},
+	}
+
+	tests := []struct {
+		name     string
+		schedule *FaultSchedule
+		seed     uint64
+	}{
+		{"seeded schedule", seeded, 42},
+		{"schedule without seed", &FaultSchedule{Rules: seeded.Rules}, 7},
+		{"seeded schedule again", seeded, 42},
+		{"schedule cleared", nil, 7},
+	}
+	for _, tt := range tests {
+		if err := fi.setSchedule(tt.schedule); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if seed := fi.scheduleStatus().Seed; seed != tt.seed {
+			t.Fatalf("%s: seed %d, want %d", tt.name, seed, tt.seed)
+		}
+	}
+
+	// Back on FAULTS_SEED, a full reset repeats the first run.
+	if err := fi.reset(""); err != nil {
+		t.Fatal(err)
+	}
+	for i, ok := range draws(fi, 50) {
+		if ok != configured[i] {
+			t.Fatalf("request %d: triggered %t, want %t as in the first run", i+1, ok, configured[i])
+		}
+	}
+}
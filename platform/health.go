@@ -0,0 +1,171 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Ready is set once the service is about to accept traffic.
+	Ready atomic.Bool
+
+	probeTimeout = probeTimeoutFromEnv()
+	probeClient  = &http.Client{}
+)
+
+func probeTimeoutFromEnv() time.Duration {
+	if value, err := time.ParseDuration(os.Getenv("READINESS_PROBE_TIMEOUT")); err == nil {
+		return value
+	}
+	return time.Second
+}
+
+// Dependency is a service probed by ReadyzWithDependencies.
+type Dependency struct {
+	Name string
+	URL  string
+	// Required dependencies make the service not ready while they are down;
+	// the others only degrade it.
+	Required bool
+	// Circuit reports the state of the breaker guarding calls to the
+	// dependency, if there is one.
+	Circuit func() string
+}
+
+type DependencyStatus struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Circuit   string  `json:"circuit,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status       string             `json:"status"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+func LivezHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, "ok", http.StatusOK)
+}
+
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !Ready.Load() {
+		writeStatus(w, "not_ready", http.StatusServiceUnavailable)
+		return
+	}
+	writeStatus(w, "ready", http.StatusOK)
+}
+
+// ReadyzWithDependencies is ReadyzHandler for a service that calls others: it
+// also probes their /readyz and lists what it found.
+func ReadyzWithDependencies(dependencies ...Dependency) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		statuses := make([]DependencyStatus, len(dependencies))
+		var wg sync.WaitGroup
+		for i, dependency := range dependencies {
+			wg.Go(func() {
+				statuses[i] = probeDependency(r.Context(), dependency)
+			})
+		}
+		wg.Wait()
+
+		response := ReadinessResponse{Status: "ready", Dependencies: statuses}
+		statusCode := http.StatusOK
+		for _, status := range statuses {
+			if status.Status == "up" {
+				continue
+			}
+			if status.Required {
+				response.Status = "not_ready"
+				statusCode = http.StatusServiceUnavailable
+				break
+			}
+			response.Status = "degraded"
+		}
+		if !Ready.Load() {
+			response.Status = "not_ready"
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func probeDependency(ctx context.Context, dependency Dependency) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	status := DependencyStatus{
+		Name:     dependency.Name,
+		Status:   "down",
+		Required: dependency.Required,
+	}
+	if dependency.Circuit != nil {
+		status.Circuit = dependency.Circuit()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dependency.URL+"/readyz", nil)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	start := time.Now()
+	resp, err := probeClient.Do(req)
+	status.LatencyMS = DurationMillis(time.Since(start))
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		status.Error = fmt.Sprintf("service returned status %d: %s", resp.StatusCode, body)
+		return status
+	}
+	status.Status = "up"
+	return status
+}
+
+func writeStatus(w http.ResponseWriter, status string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// RunHealthcheck probes /livez of the instance listening on port and returns
+// the exit code for the container healthcheck. Scratch images have no curl.
+// Liveness, not readiness: a dependency outage makes /readyz fail, and the
+// container is not broken or worth restarting because of it.
+func RunHealthcheck(port string) int {
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get("http://localhost" + port + "/livez")
+	if err != nil {
+		logger.Error("healthcheck failed", "error", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("healthcheck failed", "status", resp.StatusCode)
+		return 1
+	}
+	return 0
+}
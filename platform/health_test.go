@@ -0,0 +1,57 @@
+package platform
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyzWithDependencies(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(up.Close)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(down.Close)
+
+	previous := Ready.Load()
+	t.Cleanup(func() { Ready.Store(previous) })
+
+	tests := []struct {
+		name         string
+		ready        bool
+		dependencies []Dependency
+		wantCode     int
+		wantStatus   string
+	}{
+		{"all up", true, []Dependency{{Name: "a", URL: up.URL, Required: true}}, http.StatusOK, "ready"},
+		{"optional down", true, []Dependency{{Name: "a", URL: up.URL, Required: true}, {Name: "b", URL: down.URL}}, http.StatusOK, "degraded"},
+		{"required down", true, []Dependency{{Name: "a", URL: down.URL, Required: true}, {Name: "b", URL: up.URL}}, http.StatusServiceUnavailable, "not_ready"},
+		{"service not ready", false, []Dependency{{Name: "a", URL: up.URL, Required: true}}, http.StatusServiceUnavailable, "not_ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Ready.Store(tt.ready)
+			w := httptest.NewRecorder()
+			ReadyzWithDependencies(tt.dependencies...)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var response ReadinessResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantCode || response.Status != tt.wantStatus {
+				t.Fatalf("got %d %s, want %d %s", w.Code, response.Status, tt.wantCode, tt.wantStatus)
+			}
+			if len(response.Dependencies) != len(tt.dependencies) {
+				t.Fatalf("listed %d dependencies, want %d", len(response.Dependencies), len(tt.dependencies))
+			}
+			for i, dependency := range response.Dependencies {
+				wantUp := tt.dependencies[i].URL == up.URL
+				if (dependency.Status == "up") != wantUp || (dependency.Error == "") != wantUp {
+					t.Fatalf("dependency %s: status %s, error %q", dependency.Name, dependency.Status, dependency.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package platform
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Logs are JSON lines on stdout. Lines logged with a request's context also
+// carry its request ID, trace and any fields attached with WithLogAttrs.
+
+const requestIDHeader = "X-Request-ID"
+
+// logger is replaced by Init; until then lines go to the default logger.
+var logger = slog.Default()
+
+type requestIDContextKey struct{}
+
+type logAttrsContextKey struct{}
+
+// Init names the service in logs and exported spans and returns its logger.
+// Services call it from their first package variable, so the logger is ready
+// before the fault injector and anything else that logs while loading its
+// configuration.
+func Init(name string) *slog.Logger {
+	serviceName = name
+	logger = newLogger()
+	return logger
+}
+
+func newLogger() *slog.Logger {
+	level := slog.LevelInfo
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		level.UnmarshalText([]byte(value))
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	l := slog.New(contextHandler{handler}).With("service", serviceName)
+	slog.SetDefault(l)
+	return l
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		record.AddAttrs(slog.String("trace_id", span.TraceID), slog.String("span_id", span.SpanID))
+	}
+	if attrs, ok := ctx.Value(logAttrsContextKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// WithLogAttrs returns ctx with attrs added to every line logged with it.
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsContextKey{}).([]slog.Attr)
+	combined := make([]slog.Attr, 0, len(existing)+len(attrs))
+	combined = append(append(combined, existing...), attrs...)
+	return context.WithValue(ctx, logAttrsContextKey{}, combined)
+}
+
+// ContextWithRequestID returns ctx carrying id, for work that continues a
+// request outside its handler.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// withRequestID gives every request an ID, taken from X-Request-ID or newly
+// generated, echoes it in the response and logs the request's outcome.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = rand.Text()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := ContextWithRequestID(r.Context(), id)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch r.URL.Path {
+		case "/health", "/livez", "/readyz", "/metrics":
+			level = slog.LevelDebug
+		}
+		logger.Log(ctx, level, "request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", DurationMillis(time.Since(start)))
+	})
+}
+
+// DurationMillis converts d to the fractional milliseconds used in logs.
+func DurationMillis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,95 @@
+package platform
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the package logger's lines to a buffer for the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var out bytes.Buffer
+	previous := logger
+	logger = slog.New(contextHandler{slog.NewJSONHandler(&out, nil)})
+	t.Cleanup(func() { logger = previous })
+	return &out
+}
+
+func decodeLines(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for line := range strings.Lines(out.String()) {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestRequestLogFields(t *testing.T) {
+	out := captureLogs(t)
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithLogAttrs(r.Context(), slog.String("user", "u1"))
+		logger.InfoContext(ctx, "handling")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/buyTicket", nil)
+	r.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if got := w.Header().Get("X-Request-ID"); got != "req-1" {
+		t.Fatalf("echoed request ID %q, want req-1", got)
+	}
+	lines := decodeLines(t, out)
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want 2", len(lines))
+	}
+	if lines[0]["msg"] != "handling" || lines[0]["request_id"] != "req-1" || lines[0]["user"] != "u1" {
+		t.Fatalf("handler line %v, want request_id req-1 and user u1", lines[0])
+	}
+	done := lines[1]
+	if done["msg"] != "request completed" || done["request_id"] != "req-1" || done["method"] != "POST" ||
+		done["path"] != "/buyTicket" || done["status"] != float64(http.StatusCreated) {
+		t.Fatalf("request line %v", done)
+	}
+	if _, ok := done["duration_ms"].(float64); !ok {
+		t.Fatalf("request line has no duration_ms: %v", done)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	captureLogs(t)
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, given := range []string{"", strings.Repeat("x", 129)} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Request-ID", given)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if got := w.Header().Get("X-Request-ID"); got == "" || got == given {
+			t.Fatalf("request ID %q was not replaced, got %q", given, got)
+		}
+	}
+}
+
+func TestProbesLoggedAtDebug(t *testing.T) {
+	out := captureLogs(t)
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if out.Len() != 0 {
+		t.Fatalf("probe logged at info: %s", out)
+	}
+}
@@ -0,0 +1,245 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal Prometheus text-format registry, so the services keep building
+// from the standard library alone.
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	registry  []metric
+
+	defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	httpRequestsTotal = NewCounterVec("http_requests_total",
+		"HTTP requests handled, by endpoint, method and status.", "endpoint", "method", "status")
+	httpRequestDuration = NewHistogramVec("http_request_duration_seconds",
+		"HTTP request latency, by endpoint, method and status.", defaultBuckets, "endpoint", "method", "status")
+	faultsInjectedTotal = NewCounterVec("faults_injected_total",
+		"Requests affected by a simulated fault, by fault type.", "fault")
+)
+
+func register(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	registry = append(registry, m)
+}
+
+type series struct {
+	labels []string
+	value  float64
+}
+
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// NewCounterVec registers a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*series)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, exists := c.series[key]
+	if !exists {
+		s = &series{labels: values}
+		c.series[key] = s
+	}
+	s.value += delta
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labels), formatValue(s.value))
+	}
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogramVec registers a histogram with the given buckets and label
+// names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{labels: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	bucketLabels := append(slices.Clone(h.labels), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			values := append(slices.Clone(s.labels), formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, values), s.counts[i])
+		}
+		values := append(slices.Clone(s.labels), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels), s.count)
+	}
+}
+
+// Sample is one series of a GaugeFunc: its label values and current value.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc reports values computed at scrape time.
+type GaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() []Sample
+}
+
+// NewGaugeFunc registers a gauge whose samples fn computes on every scrape.
+func NewGaugeFunc(name, help string, labels []string, fn func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, s := range g.fn() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, s.Labels), formatValue(s.Value))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// MetricsHandler serves every registered metric in the text format.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metricsMu.Lock()
+	metrics := slices.Clone(registry)
+	metricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Instrument records the request count and latency of handler under endpoint.
+func Instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+
+		status := strconv.Itoa(recorder.status)
+		httpRequestsTotal.Inc(endpoint, r.Method, status)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), endpoint, r.Method, status)
+	}
+}
@@ -0,0 +1,80 @@
+package platform
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	counter := NewCounterVec("test_format_total", "Counted things.", "kind")
+	counter.Inc("a")
+	counter.Add(2.5, "a")
+	counter.Inc(`quote " backslash \ newline` + "\n")
+
+	histogram := NewHistogramVec("test_format_seconds", "Timed things.", []float64{0.1, 1}, "kind")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(3, "a")
+
+	gauge := NewGaugeFunc("test_format_queue", "Queued things.", []string{"queue"}, func() []Sample {
+		return []Sample{{Labels: []string{"pending"}, Value: 4}}
+	})
+
+	var out strings.Builder
+	for _, m := range []metric{counter, histogram, gauge} {
+		m.write(&out)
+	}
+	body := out.String()
+	for _, want := range []string{
+		"# HELP test_format_total Counted things.\n# TYPE test_format_total counter\n",
+		`test_format_total{kind="a"} 3.5` + "\n",
+		`test_format_total{kind="quote \" backslash \\ newline\n"} 1` + "\n",
+		"# TYPE test_format_seconds histogram\n",
+		`test_format_seconds_bucket{kind="a",le="0.1"} 1` + "\n",
+		`test_format_seconds_bucket{kind="a",le="1"} 2` + "\n",
+		`test_format_seconds_bucket{kind="a",le="+Inf"} 3` + "\n",
+		`test_format_seconds_sum{kind="a"} 3.55` + "\n",
+		`test_format_seconds_count{kind="a"} 3` + "\n",
+		"# TYPE test_format_queue gauge\n",
+		`test_format_queue{queue="pending"} 4` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics do not contain %q:\n%s", want, body)
+		}
+	}
+}
+
+// counterValue is the current value of one series of c.
+func counterValue(c *CounterVec, values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.series[strings.Join(values, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func TestInstrumentRecordsStatus(t *testing.T) {
+	handler := Instrument("/test-instrument", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "failed", http.StatusServiceUnavailable)
+		}
+	})
+	ok := counterValue(httpRequestsTotal, "/test-instrument", http.MethodGet, "200")
+	failed := counterValue(httpRequestsTotal, "/test-instrument", http.MethodGet, "503")
+
+	for _, target := range []string{"/test-instrument", "/test-instrument?fail=1", "/test-instrument"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	// A handler that never calls WriteHeader answered 200.
+	if got := counterValue(httpRequestsTotal, "/test-instrument", http.MethodGet, "200") - ok; got != 2 {
+		t.Fatalf("counted %v requests with 200, want 2", got)
+	}
+	if got := counterValue(httpRequestsTotal, "/test-instrument", http.MethodGet, "503") - failed; got != 1 {
+		t.Fatalf("counted %v requests with 503, want 1", got)
+	}
+}
@@ -0,0 +1,45 @@
+package platform
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+)
+
+var shutdownTimeout = shutdownTimeoutFromEnv()
+
+func shutdownTimeoutFromEnv() time.Duration {
+	if value, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil {
+		return value
+	}
+	return 15 * time.Second
+}
+
+// Serve runs the HTTP server until ctx is done. It then reports not ready,
+// stops accepting connections and waits up to SHUTDOWN_TIMEOUT for in-flight
+// requests to finish.
+func Serve(ctx context.Context, port string) error {
+	server := &http.Server{
+		Addr:    port,
+		Handler: withRequestID(http.DefaultServeMux),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down, draining in-flight requests", "timeout_ms", DurationMillis(shutdownTimeout))
+	Ready.Store(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,88 @@
+package platform
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	slowEntered, slowRelease chan struct{}
+	registerSlow             sync.Once
+)
+
+// freePort returns an address Serve can listen on.
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	slowEntered, slowRelease = make(chan struct{}), make(chan struct{})
+	registerSlow.Do(func() {
+		http.HandleFunc("/test-slow", func(w http.ResponseWriter, r *http.Request) {
+			close(slowEntered)
+			<-slowRelease
+			io.WriteString(w, "done")
+		})
+	})
+	previous := Ready.Load()
+	t.Cleanup(func() { Ready.Store(previous) })
+	Ready.Store(true)
+
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, addr) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		for {
+			resp, err := http.Get("http://" + addr + "/test-slow")
+			if err != nil {
+				// The server may not be listening yet.
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			responses <- result{string(body), err}
+			return
+		}
+	}()
+
+	<-slowEntered
+	cancel()
+	// Shutdown has started once the service stops reporting ready.
+	for Ready.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(slowRelease)
+	if got := <-responses; got.err != nil || got.body != "done" {
+		t.Fatalf("in-flight request got %q, %v; want done", got.body, got.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+}
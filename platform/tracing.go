@@ -0,0 +1,426 @@
+package platform
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Incoming requests continue the caller's W3C trace and outgoing ones made
+// through TracingTransport propagate it. Finished spans are written as
+// OTLP/JSON, one ExportTraceServiceRequest per line, to TRACES_FILE and/or to
+// OTEL_EXPORTER_OTLP_ENDPOINT.
+
+// serviceName is set by Init.
+var serviceName string
+
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+)
+
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         int
+
+	start         time.Time
+	end           time.Time
+	mu            sync.Mutex
+	attributes    map[string]string
+	events        []spanEvent
+	failed        bool
+	statusMessage string
+}
+
+type spanEvent struct {
+	name       string
+	at         time.Time
+	attributes map[string]string
+}
+
+type spanContextKey struct{}
+
+// SpanFromContext returns the span in ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a child of the span in ctx, or a new trace if there is none.
+func StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	span := &Span{
+		SpanID:     randomHex(8),
+		Name:       name,
+		Kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// ContextWithTraceparent returns ctx carrying a remote parent taken from a
+// traceparent header value, so spans started from it join that trace.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	traceID, spanID, ok := parseTraceparent(traceparent)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, &Span{TraceID: traceID, SpanID: spanID})
+}
+
+func parseTraceparent(value string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	if !isHex(parts[1]) || !isHex(parts[2]) || strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// Traceparent is the W3C traceparent header value that makes s the parent of
+// a remote span.
+func (s *Span) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = fmt.Sprint(value)
+}
+
+func (s *Span) AddEvent(name string, attributes map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, spanEvent{name: name, at: time.Now(), attributes: attributes})
+}
+
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.statusMessage = err.Error()
+}
+
+func (s *Span) Finish() {
+	s.mu.Lock()
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if e := exporter.Load(); e != nil {
+		e.export(s)
+	}
+}
+
+// End finishes the span, marking it failed when err is not nil.
+func (s *Span) End(err error) {
+	s.SetError(err)
+	s.Finish()
+}
+
+// AddSpanEvent records an event on the span in ctx, if any.
+func AddSpanEvent(ctx context.Context, name string, attributes map[string]string) {
+	if span := SpanFromContext(ctx); span != nil && span.attributes != nil {
+		span.AddEvent(name, attributes)
+	}
+}
+
+// Traced starts a server span for every request, continuing the caller's
+// trace when a traceparent header is present.
+func Traced(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := ContextWithTraceparent(r.Context(), r.Header.Get("traceparent"))
+		ctx, span := StartSpan(ctx, r.Method+" "+r.URL.Path, SpanKindServer)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("HTTP %d", recorder.status))
+		}
+		span.Finish()
+	}
+}
+
+// TracingTransport records a client span for every outbound request, so each
+// retry and hedged attempt shows up separately, and propagates traceparent
+// and X-Request-ID.
+type TracingTransport struct {
+	Base http.RoundTripper
+}
+
+type attemptContextKey struct{}
+
+type attemptInfo struct {
+	policy  string
+	attempt int
+}
+
+// ContextWithAttempt labels the client spans of requests made with ctx with
+// the retry policy and attempt number that sent them.
+func ContextWithAttempt(ctx context.Context, policy string, attempt int) context.Context {
+	return context.WithValue(ctx, attemptContextKey{}, attemptInfo{policy: policy, attempt: attempt})
+}
+
+func (t TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := StartSpan(req.Context(), req.Method+" "+req.URL.Host+req.URL.Path, SpanKindClient)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.String())
+	if info, ok := ctx.Value(attemptContextKey{}).(attemptInfo); ok {
+		span.SetAttribute("retry.policy", info.policy)
+		span.SetAttribute("retry.attempt", info.attempt)
+	}
+
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.Traceparent())
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetError(fmt.Errorf("HTTP %d", resp.StatusCode))
+	}
+	span.Finish()
+	return resp, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(value string) bool {
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+type spanExporter struct {
+	spans    chan *Span
+	file     *os.File
+	endpoint string
+	done     chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// exporter is set by StartTracing; until then, or with tracing off, finished
+// spans are dropped. It is atomic because background workers may finish
+// spans while it is being set.
+var exporter atomic.Pointer[spanExporter]
+
+// StartTracing opens the configured exporters. With none configured, spans
+// are still propagated but not recorded.
+func StartTracing() {
+	path := os.Getenv("TRACES_FILE")
+	endpoint := strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/")
+	if path == "" && endpoint == "" {
+		return
+	}
+
+	e := &spanExporter{
+		spans: make(chan *Span, 1024),
+		done:  make(chan struct{}),
+	}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			logger.Warn("failed to open traces file", "path", path, "error", err)
+		} else {
+			e.file = file
+		}
+	}
+	if endpoint != "" {
+		e.endpoint = endpoint + "/v1/traces"
+	}
+
+	go e.run()
+	exporter.Store(e)
+	logger.Info("exporting spans", "file", path, "endpoint", e.endpoint)
+}
+
+// StopTracing writes out the spans still buffered and closes the exporters.
+func StopTracing() {
+	e := exporter.Load()
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	e.closed = true
+	close(e.spans)
+	e.mu.Unlock()
+
+	<-e.done
+	if e.file != nil {
+		e.file.Close()
+	}
+}
+
+func (e *spanExporter) export(span *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- span:
+	default:
+		logger.Warn("span export buffer full, dropping span", "span", span.Name)
+	}
+}
+
+func (e *spanExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				e.flush(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= 100 {
+				e.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+func (e *spanExporter) flush(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(otlpRequest(batch))
+	if err != nil {
+		logger.Warn("failed to encode spans", "error", err)
+		return
+	}
+
+	if e.file != nil {
+		if _, err := e.file.Write(append(payload, '\n')); err != nil {
+			logger.Warn("failed to write spans", "error", err)
+		}
+	}
+	if e.endpoint != "" {
+		client := &http.Client{Timeout: 2 * time.Second}
+		resp, err := client.Post(e.endpoint, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			logger.Warn("failed to send spans", "error", err)
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+func otlpRequest(batch []*Span) map[string]any {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, span.otlp())
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]string{"service.name": serviceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": serviceName},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+func (s *Span) otlp() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := map[string]any{"code": 1}
+	if s.failed {
+		status = map[string]any{"code": 2, "message": s.statusMessage}
+	}
+
+	events := make([]map[string]any, 0, len(s.events))
+	for _, event := range s.events {
+		events = append(events, map[string]any{
+			"name":         event.name,
+			"timeUnixNano": strconv.FormatInt(event.at.UnixNano(), 10),
+			"attributes":   otlpAttributes(event.attributes),
+		})
+	}
+
+	span := map[string]any{
+		"traceId":           s.TraceID,
+		"spanId":            s.SpanID,
+		"name":              s.Name,
+		"kind":              s.Kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attributes),
+		"events":            events,
+		"status":            status,
+	}
+	if s.ParentSpanID != "" {
+		span["parentSpanId"] = s.ParentSpanID
+	}
+	return span
+}
+
+func otlpAttributes(attributes map[string]string) []map[string]any {
+	result := make([]map[string]any, 0, len(attributes))
+	for _, key := range sortedKeys(attributes) {
+		result = append(result, map[string]any{
+			"key":   key,
+			"value": map[string]any{"stringValue": attributes[key]},
+		})
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package platform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSpansFinishedWhileTracingStarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("TRACES_FILE", path)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+
+	// Background workers finish spans whenever they like, including while
+	// the exporter is being set up.
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for i := range 100 {
+			_, span := StartSpan(context.Background(), "worker", SpanKindInternal)
+			span.End(nil)
+			if i == 0 {
+				close(started)
+			}
+		}
+	})
+
+	<-started
+	StartTracing()
+	wg.Wait()
+	_, span := StartSpan(context.Background(), "after_start", SpanKindInternal)
+	span.Finish()
+	StopTracing()
+	t.Cleanup(func() { exporter.Store(nil) })
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"name":"after_start"`) {
+		t.Fatal("span finished after StartTracing was not exported")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"tools/internal/load"
+)
+
+// audit holds what the services recorded for one run, compared with what
+// its clients were told.
+type audit struct {
+	Sold           int
+	LostBonuses    int
+	PendingBonuses int
+	// Inconsistent counts transactions where AirlinesHub, Fidelity and the
+	// client disagree: a sale the client saw fail, a confirmed purchase with
+	// no sale, or a bonus for a sale that was cancelled.
+	Inconsistent int
+}
+
+// auditRun compares the results of a run with the transactions AirlinesHub
+// created during it, the bonuses Fidelity holds for them and IMDTravel's
+// bonus queues.
+func auditRun(results []load.Result, created []transaction, bonuses, pending, dead map[string]bool) audit {
+	var a audit
+
+	confirmed := make(map[string]bool)
+	for _, result := range results {
+		if result.Success {
+			confirmed[result.TransactionID] = true
+		}
+	}
+
+	sold := make(map[string]bool)
+	for _, tx := range created {
+		switch {
+		case tx.Status == "sold":
+			sold[tx.ID] = true
+			a.Sold++
+			if !confirmed[tx.ID] {
+				a.Inconsistent++
+			}
+		case bonuses[tx.ID]:
+			a.Inconsistent++
+		}
+	}
+
+	for id := range confirmed {
+		switch {
+		case !sold[id]:
+			a.Inconsistent++
+		case bonuses[id]:
+		case pending[id] && !dead[id]:
+			a.PendingBonuses++
+		default:
+			a.LostBonuses++
+		}
+	}
+	return a
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"tools/internal/load"
+)
+
+func TestAuditRun(t *testing.T) {
+	confirmed := func(id string) load.Result { return load.Result{Success: true, TransactionID: id} }
+	failed := load.Result{Class: "503 buy"}
+	ids := func(list ...string) map[string]bool {
+		set := make(map[string]bool)
+		for _, id := range list {
+			set[id] = true
+		}
+		return set
+	}
+
+	tests := []struct {
+		name    string
+		results []load.Result
+		created []transaction
+		bonuses map[string]bool
+		pending map[string]bool
+		dead    map[string]bool
+		want    audit
+	}{
+		{
+			name:    "sale with its bonus",
+			results: []load.Result{confirmed("t1")},
+			created: []transaction{{ID: "t1", Status: "sold"}},
+			bonuses: ids("t1"),
+			want:    audit{Sold: 1},
+		},
+		{
+			name:    "bonus still queued",
+			results: []load.Result{confirmed("t1")},
+			created: []transaction{{ID: "t1", Status: "sold"}},
+			pending: ids("t1"),
+			want:    audit{Sold: 1, PendingBonuses: 1},
+		},
+		{
+			name:    "bonus in the dead letters",
+			results: []load.Result{confirmed("t1")},
+			created: []transaction{{ID: "t1", Status: "sold"}},
+			pending: ids("t1"),
+			dead:    ids("t1"),
+			want:    audit{Sold: 1, LostBonuses: 1},
+		},
+		{
+			name:    "bonus missing everywhere",
+			results: []load.Result{confirmed("t1")},
+			created: []transaction{{ID: "t1", Status: "sold"}},
+			want:    audit{Sold: 1, LostBonuses: 1},
+		},
+		{
+			name:    "sale the client saw fail",
+			results: []load.Result{failed},
+			created: []transaction{{ID: "t1", Status: "sold"}},
+			want:    audit{Sold: 1, Inconsistent: 1},
+		},
+		{
+			name:    "confirmed purchase without a sale",
+			results: []load.Result{confirmed("t1")},
+			created: []transaction{{ID: "t1", Status: "cancelled"}},
+			bonuses: ids("t1"),
+			want:    audit{Inconsistent: 2},
+		},
+		{
+			name:    "compensated purchase",
+			results: []load.Result{failed},
+			created: []transaction{{ID: "t1", Status: "cancelled"}, {ID: "h1", Status: "released"}},
+			want:    audit{},
+		},
+		{
+			name:    "bonus for a cancelled sale",
+			results: []load.Result{failed},
+			created: []transaction{{ID: "t1", Status: "cancelled"}},
+			bonuses: ids("t1"),
+			want:    audit{Inconsistent: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditRun(tt.results, tt.created, tt.bonuses, tt.pending, tt.dead); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
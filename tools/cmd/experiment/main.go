@@ -0,0 +1,279 @@
+// Command experiment runs the same workload with ft=false and ft=true under
+// seeded fault scenarios and reports availability, latency, lost bonuses and
+// inconsistent transactions for each run.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"time"
+
+	"tools/internal/load"
+)
+
+// scenario is a fault schedule for each service, in the format of their
+// PUT /faults/schedule. Seed applies to every schedule without its own.
+type scenario struct {
+	Name   string              `json:"name"`
+	Seed   *uint64             `json:"seed,omitempty"`
+	Faults map[string]schedule `json:"faults"`
+}
+
+type schedule struct {
+	Seed  *uint64           `json:"seed,omitempty"`
+	Rules []json.RawMessage `json:"rules"`
+}
+
+type options struct {
+	load.Config
+	settle       time.Duration
+	pause        time.Duration
+	readyTimeout time.Duration
+}
+
+func main() {
+	urls := map[string]string{}
+	flag.Func("imdtravel", "IMDTravel base URL (default http://localhost:8080)", setURL(urls, "imdtravel"))
+	flag.Func("airlineshub", "AirlinesHub base URL (default http://localhost:8081)", setURL(urls, "airlineshub"))
+	flag.Func("exchange", "Exchange base URL (default http://localhost:8082)", setURL(urls, "exchange"))
+	flag.Func("fidelity", "Fidelity base URL (default http://localhost:8083)", setURL(urls, "fidelity"))
+	var (
+		workload     = flag.String("workload", "workloads/purchases.jsonl", "JSONL file of purchases")
+		mode         = flag.String("ft", "both", "runs to compare: both, on or off")
+		rate         = flag.Float64("rate", 10, "requests per second, 0 for no limit")
+		concurrency  = flag.Int("concurrency", 1, "concurrent requests; above 1 the order requests reach the faults may vary between runs")
+		requests     = flag.Int("n", 100, "requests per run, cycling through the workload")
+		timeout      = flag.Duration("timeout", 15*time.Second, "client timeout per request")
+		settle       = flag.Duration("settle", time.Minute, "how long to wait for pending bonuses before auditing a run")
+		pause        = flag.Duration("pause", 10*time.Second, "pause between runs, so circuit breakers can close")
+		readyTimeout = flag.Duration("ready-timeout", time.Minute, "how long to wait for the services to be ready")
+		format       = flag.String("format", "markdown", "report format: markdown or csv")
+		output       = flag.String("out", "", "report file (default stdout)")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: experiment [flags] [scenario.json...]")
+		fmt.Fprintln(flag.CommandLine.Output(), "ADMIN_TOKEN must hold the admin token of the services, to load the fault schedules.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	for name, port := range map[string]string{"imdtravel": "8080", "airlineshub": "8081", "exchange": "8082", "fidelity": "8083"} {
+		if urls[name] == "" {
+			urls[name] = "http://localhost:" + port
+		}
+	}
+
+	modes := []string{load.FTOff, load.FTOn}
+	if *mode == load.FTOn || *mode == load.FTOff {
+		modes = []string{*mode}
+	} else if *mode != "both" {
+		fatalf("invalid -ft %q", *mode)
+	}
+	if *format != "markdown" && *format != "csv" {
+		fatalf("invalid -format %q", *format)
+	}
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"scenarios/mixed.json"}
+	}
+	scenarios := make([]scenario, 0, len(paths))
+	for _, path := range paths {
+		sc, err := readScenario(path)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		scenarios = append(scenarios, sc)
+	}
+
+	purchases, _, err := load.ReadWorkload(*workload)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := options{
+		Config: load.Config{
+			URL:         urls["imdtravel"],
+			Rate:        *rate,
+			Concurrency: *concurrency,
+			Requests:    *requests,
+			Timeout:     *timeout,
+		},
+		settle:       *settle,
+		pause:        *pause,
+		readyTimeout: *readyTimeout,
+	}
+	s := newStack(urls, os.Getenv("ADMIN_TOKEN"))
+	runID := "experiment-" + strconv.FormatInt(time.Now().Unix(), 36)
+
+	var rows []row
+	for _, sc := range scenarios {
+		for _, mode := range modes {
+			if len(rows) > 0 {
+				time.Sleep(opts.pause)
+			}
+
+			cfg := opts
+			cfg.Mode = mode
+			cfg.RunID = fmt.Sprintf("%s-%s-%s", runID, sc.Name, mode)
+			fmt.Fprintf(os.Stderr, "running scenario %s with ft %s\n", sc.Name, mode)
+
+			r, err := run(ctx, s, sc, cfg, purchases)
+			if err != nil {
+				fatalf("scenario %s, ft %s: %v", sc.Name, mode, err)
+			}
+			rows = append(rows, r)
+		}
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if *format == "csv" {
+		if err := writeCSV(out, rows); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	writeMarkdown(out, rows)
+}
+
+// run applies the scenario, replays the workload and audits what the
+// services recorded for it.
+func run(ctx context.Context, s *stack, sc scenario, opts options, purchases []load.Purchase) (row, error) {
+	if err := s.waitReady(ctx, opts.readyTimeout); err != nil {
+		return row{}, err
+	}
+	if err := s.applyScenario(ctx, sc); err != nil {
+		return row{}, err
+	}
+	before, err := s.transactions(ctx)
+	if err != nil {
+		return row{}, err
+	}
+
+	// A service that crashes during the load comes back without the
+	// scenario, so it is loaded again as soon as the service answers.
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	go s.watchRestarts(watchCtx, sc)
+	start := time.Now()
+	results := load.Run(ctx, opts.Config, purchases)
+	report := load.Summarize(opts.Mode, results, time.Since(start))
+	stopWatch()
+	if ctx.Err() != nil {
+		return row{}, ctx.Err()
+	}
+
+	after, err := s.transactions(ctx)
+	if err != nil {
+		return row{}, err
+	}
+	existing := make(map[string]bool, len(before))
+	for _, tx := range before {
+		existing[tx.ID] = true
+	}
+	ids := make(map[string]bool)
+	for _, tx := range after {
+		if !existing[tx.ID] {
+			ids[tx.ID] = true
+		}
+	}
+
+	if err := s.waitSettled(ctx, ids, opts.settle); err != nil {
+		return row{}, err
+	}
+	// A crashed Fidelity has to be back before its bonuses can be read.
+	if err := s.waitReady(ctx, opts.readyTimeout); err != nil {
+		return row{}, err
+	}
+	if err := s.reapplyRestarted(ctx, sc); err != nil {
+		return row{}, err
+	}
+
+	// Compensations may have cancelled sales while the queue settled.
+	after, err = s.transactions(ctx)
+	if err != nil {
+		return row{}, err
+	}
+	created := slices.DeleteFunc(after, func(tx transaction) bool { return !ids[tx.ID] })
+
+	bonuses, err := s.bonuses(ctx, users(purchases))
+	if err != nil {
+		return row{}, err
+	}
+	pending, dead, err := s.queued(ctx)
+	if err != nil {
+		return row{}, err
+	}
+	if err := s.cancelSales(ctx, created); err != nil {
+		return row{}, err
+	}
+
+	r := row{
+		Scenario: sc.Name,
+		Report:   report,
+		audit:    auditRun(results, created, bonuses, pending, dead),
+	}
+	if sc.Seed != nil {
+		r.Seed = strconv.FormatUint(*sc.Seed, 10)
+	}
+	return r, nil
+}
+
+func readScenario(path string) (scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario{}, err
+	}
+
+	var sc scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return scenario{}, fmt.Errorf("%s: %w", path, err)
+	}
+	for name := range sc.Faults {
+		if !slices.Contains(faultServices, name) {
+			return scenario{}, fmt.Errorf("%s: unknown service %q", path, name)
+		}
+	}
+	if sc.Name == "" {
+		sc.Name = path
+	}
+	return sc, nil
+}
+
+func users(purchases []load.Purchase) []string {
+	var list []string
+	for _, purchase := range purchases {
+		if !slices.Contains(list, purchase.User) {
+			list = append(list, purchase.User)
+		}
+	}
+	return list
+}
+
+func setURL(urls map[string]string, name string) func(string) error {
+	return func(value string) error {
+		urls[name] = value
+		return nil
+	}
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+
+	"tools/internal/load"
+)
+
+type row struct {
+	Scenario string
+	Seed     string
+	load.Report
+	audit
+}
+
+var csvHeader = []string{
+	"scenario", "seed", "ft", "requests", "succeeded", "availability",
+	"p50_ms", "p95_ms", "p99_ms", "max_ms",
+	"sold", "lost_bonuses", "pending_bonuses", "inconsistent",
+}
+
+func writeCSV(w io.Writer, rows []row) error {
+	out := csv.NewWriter(w)
+	out.Write(csvHeader)
+	for _, r := range rows {
+		out.Write([]string{
+			r.Scenario, r.Seed, r.Mode,
+			strconv.Itoa(r.Requests), strconv.Itoa(r.Succeeded), formatFloat(r.SuccessRate, 4),
+			formatFloat(r.Latency.P50, 1), formatFloat(r.Latency.P95, 1), formatFloat(r.Latency.P99, 1), formatFloat(r.Latency.Max, 1),
+			strconv.Itoa(r.Sold), strconv.Itoa(r.LostBonuses), strconv.Itoa(r.PendingBonuses), strconv.Itoa(r.Inconsistent),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeMarkdown(w io.Writer, rows []row) {
+	fmt.Fprintln(w, "# Experiment report")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| scenario | seed | ft | requests | availability | p50 ms | p95 ms | p99 ms | sold | lost bonuses | pending bonuses | inconsistent |")
+	fmt.Fprintln(w, "|---|---|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|")
+	for _, r := range rows {
+		fmt.Fprintf(w, "| %s | %s | %s | %d | %.1f%% | %.1f | %.1f | %.1f | %d | %d | %d | %d |\n",
+			r.Scenario, r.Seed, r.Mode, r.Requests, 100*r.SuccessRate,
+			r.Latency.P50, r.Latency.P95, r.Latency.P99,
+			r.Sold, r.LostBonuses, r.PendingBonuses, r.Inconsistent)
+	}
+
+	for _, r := range rows {
+		if len(r.Errors) == 0 && len(r.BonusStatus) == 0 && r.Searches == nil {
+			continue
+		}
+		fmt.Fprintf(w, "\n## %s, ft %s\n\n", r.Scenario, r.Mode)
+		writeCounts(w, "bonus_status", r.BonusStatus)
+		writeCounts(w, "errors", r.Errors)
+		if s := r.Searches; s != nil {
+			fmt.Fprintf(w, "- searches: %d/%d (%.1f%%), p50 %.1f ms, p95 %.1f ms\n",
+				s.Succeeded, s.Requests, 100*s.SuccessRate, s.Latency.P50, s.Latency.P95)
+			writeCounts(w, "search errors", s.Errors)
+		}
+	}
+}
+
+func writeCounts(w io.Writer, title string, counts map[string]int) {
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(w, "- %s `%s`: %d\n", title, key, counts[key])
+	}
+}
+
+func formatFloat(value float64, precision int) string {
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"strings"
+	"testing"
+
+	"tools/internal/load"
+)
+
+var testRows = []row{{
+	Scenario: "mixed",
+	Seed:     "42",
+	Report: load.Report{
+		Mode:        load.FTOn,
+		Requests:    10,
+		Succeeded:   9,
+		SuccessRate: 0.9,
+		Latency:     load.Latencies{P50: 12.34, P95: 80, P99: 120.5, Max: 130},
+		BonusStatus: map[string]int{"credited": 8, "pending": 1},
+		Errors:      map[string]int{"503 buy": 1},
+	},
+	audit: audit{Sold: 9, PendingBonuses: 1},
+}}
+
+func TestWriteCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeCSV(&out, testRows); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !slices.Equal(records[0], csvHeader) {
+		t.Fatalf("got %d records with header %v", len(records), records[0])
+	}
+	want := []string{"mixed", "42", "on", "10", "9", "0.9000", "12.3", "80.0", "120.5", "130.0", "9", "0", "1", "0"}
+	if !slices.Equal(records[1], want) {
+		t.Fatalf("row %v, want %v", records[1], want)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var out bytes.Buffer
+	writeMarkdown(&out, testRows)
+	report := out.String()
+
+	for _, want := range []string{
+		"| mixed | 42 | on | 10 | 90.0% | 12.3 | 80.0 | 120.5 | 9 | 0 | 1 | 0 |",
+		"## mixed, ft on",
+		"- bonus_status `credited`: 8\n- bonus_status `pending`: 1\n",
+		"- errors `503 buy`: 1",
+	} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("report does not contain %q:\n%s", want, report)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// faultServices are the services with a /faults API, in the order scenarios
+// are applied.
+var faultServices = []string{"airlineshub", "exchange", "fidelity"}
+
+// stack talks to a running set of services, started by docker compose or
+// by hand. adminToken is sent with every request, since the /faults API and
+// the admin listings only answer to it.
+type stack struct {
+	urls       map[string]string
+	adminToken string
+	client     *http.Client
+
+	// started is when each fault service last loaded its schedule. A
+	// service that answers with another time restarted and lost it.
+	started map[string]time.Time
+	mu      sync.Mutex
+}
+
+type scheduleStatus struct {
+	StartedAt time.Time `json:"started_at"`
+}
+
+type transaction struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type queueEntry struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+// userPoints is a Fidelity ledger. A reversal is a negative record with the
+// TransactionID of the bonus it undoes.
+type userPoints struct {
+	Records []struct {
+		TransactionID string
+		Bonus         int
+	}
+}
+
+func newStack(urls map[string]string, adminToken string) *stack {
+	return &stack{
+		urls:       urls,
+		adminToken: adminToken,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		started:    make(map[string]time.Time),
+	}
+}
+
+// waitReady waits until every service answers /readyz with 200, so a run
+// does not start while a crashed service is still restarting.
+func (s *stack) waitReady(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for name, base := range s.urls {
+		for {
+			err := s.get(ctx, base+"/readyz", nil)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s not ready: %w", name, err)
+			}
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// applyScenario loads the scenario's schedule into every fault service,
+// which also restarts their generators, counters and clocks. Services the
+// scenario leaves out get an empty schedule and inject no faults.
+func (s *stack) applyScenario(ctx context.Context, sc scenario) error {
+	for _, name := range faultServices {
+		if err := s.applySchedule(ctx, sc, name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *stack) applySchedule(ctx context.Context, sc scenario, name string) error {
+	schedule := sc.Faults[name]
+	if schedule.Seed == nil {
+		schedule.Seed = sc.Seed
+	}
+	if schedule.Rules == nil {
+		schedule.Rules = []json.RawMessage{}
+	}
+
+	body, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.urls[name]+"/faults/schedule", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	var status scheduleStatus
+	if err := s.do(req, &status); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.started[name] = status.StartedAt
+	s.mu.Unlock()
+	return nil
+}
+
+// reapplyRestarted loads the scenario again into the fault services that
+// restarted since it was applied, such as Fidelity after a crash. A restart
+// drops the schedule, and the service would go back to its default faults
+// with a random seed. Services that do not answer are skipped.
+func (s *stack) reapplyRestarted(ctx context.Context, sc scenario) error {
+	for _, name := range faultServices {
+		var status scheduleStatus
+		if err := s.get(ctx, s.urls[name]+"/faults/schedule", &status); err != nil {
+			continue
+		}
+		s.mu.Lock()
+		restarted := !status.StartedAt.Equal(s.started[name])
+		s.mu.Unlock()
+		if !restarted {
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "%s restarted, loading the scenario again\n", name)
+		if err := s.applySchedule(ctx, sc, name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// watchRestarts calls reapplyRestarted every second until ctx is done.
+func (s *stack) watchRestarts(ctx context.Context, sc scenario) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reapplyRestarted(ctx, sc); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "reloading the scenario: %v\n", err)
+			}
+		}
+	}
+}
+
+// cancelSales cancels the sales and holds a run left behind, so every run
+// starts from the same free seats. Without it the second run of a scenario
+// would meet the sold-out cabins of the first.
+func (s *stack) cancelSales(ctx context.Context, created []transaction) error {
+	for _, tx := range created {
+		if tx.Status != "sold" && tx.Status != "held" {
+			continue
+		}
+		body, err := json.Marshal(map[string]string{"id": tx.ID})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.urls["airlineshub"]+"/cancel", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if err := s.do(req, nil); err != nil {
+			return fmt.Errorf("cancelling %s: %w", tx.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *stack) transactions(ctx context.Context) ([]transaction, error) {
+	var list []transaction
+	err := s.get(ctx, s.urls["airlineshub"]+"/transactions", &list)
+	return list, err
+}
+
+// bonuses returns the transaction IDs Fidelity holds a bonus for, leaving
+// out those whose bonus was reversed.
+func (s *stack) bonuses(ctx context.Context, users []string) (map[string]bool, error) {
+	net := make(map[string]int)
+	for _, user := range users {
+		var points userPoints
+		if err := s.get(ctx, s.urls["fidelity"]+"/points?user="+url.QueryEscape(user), &points); err != nil {
+			return nil, err
+		}
+		for _, record := range points.Records {
+			net[record.TransactionID] += record.Bonus
+		}
+	}
+
+	registered := make(map[string]bool)
+	for id, bonus := range net {
+		if bonus > 0 {
+			registered[id] = true
+		}
+	}
+	return registered, nil
+}
+
+// queued returns the transaction IDs whose bonus is still in IMDTravel's
+// pending queue and those parked as dead letters.
+func (s *stack) queued(ctx context.Context) (map[string]bool, map[string]bool, error) {
+	var pending, dead []queueEntry
+	if err := s.get(ctx, s.urls["imdtravel"]+"/admin/pending", &pending); err != nil {
+		return nil, nil, err
+	}
+	if err := s.get(ctx, s.urls["imdtravel"]+"/admin/dead-letters", &dead); err != nil {
+		return nil, nil, err
+	}
+	return transactionSet(pending), transactionSet(dead), nil
+}
+
+// waitSettled waits up to timeout for the pending queue to drain the
+// bonuses of the given transactions.
+func (s *stack) waitSettled(ctx context.Context, ids map[string]bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		pending, _, err := s.queued(ctx)
+		if err != nil {
+			return err
+		}
+		settled := true
+		for id := range pending {
+			if ids[id] {
+				settled = false
+				break
+			}
+		}
+		if settled || time.Now().After(deadline) {
+			return nil
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *stack) get(ctx context.Context, target string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, out)
+}
+
+func (s *stack) do(req *http.Request, out any) error {
+	if s.adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.adminToken)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: HTTP %d: %s", req.Method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func transactionSet(entries []queueEntry) map[string]bool {
+	set := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		set[entry.TransactionID] = true
+	}
+	return set
+}
@@ -0,0 +1,85 @@
+// Command loadgen replays a JSONL workload of purchases against IMDTravel's
+// /buyTicket and reports success rate, latency percentiles, bonus_status
+// counts and error classes.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"tools/internal/load"
+)
+
+func main() {
+	var (
+		url         = flag.String("url", "http://localhost:8080", "IMDTravel base URL")
+		workload    = flag.String("workload", "workloads/purchases.jsonl", "JSONL file of purchases")
+		mode        = flag.String("ft", "both", "ft flag sent: on, off, both (one run each) or file (as in the workload)")
+		rate        = flag.Float64("rate", 10, "requests per second, 0 for no limit")
+		concurrency = flag.Int("concurrency", 4, "concurrent requests")
+		requests    = flag.Int("n", 0, "requests per run, cycling through the workload (0 sends each purchase once)")
+		timeout     = flag.Duration("timeout", 15*time.Second, "client timeout per request")
+		jsonOutput  = flag.Bool("json", false, "print the reports as JSON")
+	)
+	flag.Parse()
+
+	var modes []string
+	switch *mode {
+	case load.FTOn, load.FTOff, load.FTFile:
+		modes = []string{*mode}
+	case "both":
+		modes = []string{load.FTOff, load.FTOn}
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -ft %q\n", *mode)
+		os.Exit(2)
+	}
+
+	purchases, skipped, err := load.ReadWorkload(*workload)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d lines without flight, day and user\n", skipped)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	runID := "loadgen-" + strconv.FormatInt(time.Now().Unix(), 36)
+	var reports []load.Report
+	for _, mode := range modes {
+		cfg := load.Config{
+			URL:         *url,
+			Mode:        mode,
+			Rate:        *rate,
+			Concurrency: *concurrency,
+			Requests:    *requests,
+			Timeout:     *timeout,
+			RunID:       runID + "-" + mode,
+		}
+
+		start := time.Now()
+		results := load.Run(ctx, cfg, purchases)
+		report := load.Summarize(mode, results, time.Since(start))
+		reports = append(reports, report)
+		if !*jsonOutput {
+			report.Print(os.Stdout)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(reports)
+	}
+}
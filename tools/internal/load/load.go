@@ -0,0 +1,255 @@
+// Package load replays purchase workloads against IMDTravel's /buyTicket,
+// with an optional /searchFlights step before each purchase, and summarises
+// the outcome.
+package load
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FT modes decide the ft flag sent with each purchase.
+const (
+	FTOn   = "on"
+	FTOff  = "off"
+	FTFile = "file"
+)
+
+type Config struct {
+	URL         string
+	Mode        string
+	Rate        float64
+	Concurrency int
+	// Requests is the number of purchases to send, cycling through the
+	// workload. Zero sends each purchase once.
+	Requests int
+	Timeout  time.Duration
+	// RunID prefixes the X-Request-ID and request_id of every request, so a
+	// run can be found in the service logs and never replays responses cached
+	// for an earlier run.
+	RunID string
+}
+
+type Result struct {
+	Seq           int
+	Purchase      Purchase
+	Status        int
+	Latency       time.Duration
+	Success       bool
+	TransactionID string
+	BonusStatus   string
+	Class         string
+	// Search is the outcome of the /searchFlights step, for purchases whose
+	// workload line has one.
+	Search *SearchResult
+}
+
+type SearchResult struct {
+	Status  int
+	Latency time.Duration
+	Success bool
+	Class   string
+}
+
+type buyTicketResponse struct {
+	Success       bool   `json:"success"`
+	Error         string `json:"error"`
+	TransactionID string `json:"transaction_id"`
+	BonusStatus   string `json:"bonus_status"`
+}
+
+// Run sends the purchases at up to cfg.Rate per second (unlimited when zero)
+// from cfg.Concurrency workers and returns the results in send order.
+func Run(ctx context.Context, cfg Config, workload []Purchase) []Result {
+	total := cfg.Requests
+	if total <= 0 {
+		total = len(workload)
+	}
+	concurrency := max(cfg.Concurrency, 1)
+	client := &http.Client{Timeout: cfg.Timeout}
+
+	jobs := make(chan int)
+	results := make([]Result, total)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Go(func() {
+			for seq := range jobs {
+				results[seq] = send(ctx, client, cfg, seq, purchaseFor(cfg, workload, seq))
+			}
+		})
+	}
+
+	var tick <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	sent := 0
+dispatch:
+	for ; sent < total; sent++ {
+		if tick != nil && sent > 0 {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		select {
+		case jobs <- sent:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results[:sent]
+}
+
+// purchaseFor returns the purchase sent as request seq. Repeated request_ids
+// within a pass still hit IMDTravel's idempotency cache, but each pass gets
+// its own.
+func purchaseFor(cfg Config, workload []Purchase, seq int) Purchase {
+	purchase := workload[seq%len(workload)]
+	if purchase.RequestID != "" {
+		purchase.RequestID = fmt.Sprintf("%s-%d", purchase.RequestID, seq/len(workload))
+		if cfg.RunID != "" {
+			purchase.RequestID = cfg.RunID + "-" + purchase.RequestID
+		}
+	}
+
+	switch cfg.Mode {
+	case FTOn:
+		purchase.FT = true
+	case FTOff:
+		purchase.FT = false
+	}
+	return purchase
+}
+
+func send(ctx context.Context, client *http.Client, cfg Config, seq int, purchase Purchase) Result {
+	result := Result{Seq: seq, Purchase: purchase}
+	if len(purchase.Search) > 0 {
+		search := searchFlights(ctx, client, cfg, seq, purchase)
+		result.Search = &search
+	}
+
+	// The purchase goes ahead whatever the search returned, so the
+	// purchases of a run do not depend on how the searches fared.
+	purchase.Search = nil
+	body, _ := json.Marshal(purchase)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(cfg.URL, "/")+"/buyTicket", bytes.NewReader(body))
+	if err != nil {
+		result.Class = "invalid request"
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.RunID != "" {
+		req.Header.Set("X-Request-ID", fmt.Sprintf("%s-%d", cfg.RunID, seq))
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Latency = time.Since(start)
+		result.Class = transportClass(err)
+		return result
+	}
+	defer resp.Body.Close()
+
+	var response buyTicketResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&response)
+	result.Latency = time.Since(start)
+	result.Status = resp.StatusCode
+
+	switch {
+	case resp.StatusCode == http.StatusOK && response.Success:
+		result.Success = true
+		result.TransactionID = response.TransactionID
+		result.BonusStatus = response.BonusStatus
+	case decodeErr != nil:
+		result.Class = fmt.Sprintf("%d invalid response", resp.StatusCode)
+	default:
+		result.Class = fmt.Sprintf("%d %s", resp.StatusCode, errorSummary(response.Error))
+	}
+	return result
+}
+
+// searchFlights sends the search of a purchase to /searchFlights, with the
+// purchase's ft flag.
+func searchFlights(ctx context.Context, client *http.Client, cfg Config, seq int, purchase Purchase) SearchResult {
+	query := url.Values{}
+	for key, value := range purchase.Search {
+		query.Set(key, value)
+	}
+	query.Set("ft", strconv.FormatBool(purchase.FT))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cfg.URL, "/")+"/searchFlights?"+query.Encode(), nil)
+	if err != nil {
+		return SearchResult{Class: "invalid request"}
+	}
+	if cfg.RunID != "" {
+		req.Header.Set("X-Request-ID", fmt.Sprintf("%s-%d-search", cfg.RunID, seq))
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return SearchResult{Latency: time.Since(start), Class: transportClass(err)}
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Error string `json:"error"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&response)
+	result := SearchResult{Status: resp.StatusCode, Latency: time.Since(start)}
+
+	switch {
+	case resp.StatusCode == http.StatusOK && decodeErr == nil:
+		result.Success = true
+	case decodeErr != nil:
+		result.Class = fmt.Sprintf("%d invalid response", resp.StatusCode)
+	default:
+		result.Class = fmt.Sprintf("%d %s", resp.StatusCode, errorSummary(response.Error))
+	}
+	return result
+}
+
+// errorSummary keeps the part of an IMDTravel error message that names the
+// failed step, dropping the details that differ from request to request.
+func errorSummary(message string) string {
+	if i := strings.IndexAny(message, ":.("); i >= 0 {
+		message = message[:i]
+	}
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return "error"
+	}
+	return message
+}
+
+func transportClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "client timeout"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "connection refused"
+	default:
+		return "network error"
+	}
+}
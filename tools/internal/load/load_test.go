@@ -0,0 +1,38 @@
+package load
+
+import "testing"
+
+func TestPurchaseFor(t *testing.T) {
+	workload := []Purchase{
+		{Flight: "AA123", Day: "2025-11-15", User: "u1", RequestID: "r1", FT: true},
+		{Flight: "AA456", Day: "2025-11-20", User: "u2"},
+	}
+
+	tests := []struct {
+		name          string
+		cfg           Config
+		seq           int
+		wantFlight    string
+		wantRequestID string
+		wantFT        bool
+	}{
+		{"first pass", Config{Mode: FTFile}, 0, "AA123", "r1-0", true},
+		{"second pass repeats the file", Config{Mode: FTFile}, 2, "AA123", "r1-1", true},
+		{"line without request_id", Config{Mode: FTFile}, 1, "AA456", "", false},
+		{"run prefix", Config{Mode: FTFile, RunID: "run"}, 0, "AA123", "run-r1-0", true},
+		{"ft forced off", Config{Mode: FTOff}, 0, "AA123", "r1-0", false},
+		{"ft forced on", Config{Mode: FTOn}, 1, "AA456", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			purchase := purchaseFor(tt.cfg, workload, tt.seq)
+			if purchase.Flight != tt.wantFlight || purchase.RequestID != tt.wantRequestID || purchase.FT != tt.wantFT {
+				t.Fatalf("got %s %q ft=%t, want %s %q ft=%t",
+					purchase.Flight, purchase.RequestID, purchase.FT, tt.wantFlight, tt.wantRequestID, tt.wantFT)
+			}
+		})
+	}
+	if workload[0].RequestID != "r1" {
+		t.Fatal("purchaseFor changed the workload")
+	}
+}
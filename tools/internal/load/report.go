@@ -0,0 +1,137 @@
+package load
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+type Report struct {
+	Mode        string         `json:"mode"`
+	Requests    int            `json:"requests"`
+	Succeeded   int            `json:"succeeded"`
+	SuccessRate float64        `json:"success_rate"`
+	Elapsed     float64        `json:"elapsed_s"`
+	Throughput  float64        `json:"throughput_rps"`
+	Latency     Latencies      `json:"latency"`
+	BonusStatus map[string]int `json:"bonus_status"`
+	Errors      map[string]int `json:"errors"`
+	// Searches is nil when the workload has no search steps.
+	Searches *SearchReport `json:"searches,omitempty"`
+}
+
+// SearchReport summarises the /searchFlights steps of a run. They are not
+// counted in the purchase figures.
+type SearchReport struct {
+	Requests    int            `json:"requests"`
+	Succeeded   int            `json:"succeeded"`
+	SuccessRate float64        `json:"success_rate"`
+	Latency     Latencies      `json:"latency"`
+	Errors      map[string]int `json:"errors"`
+}
+
+// Latencies are nearest-rank percentiles over all requests, failed ones
+// included, since a slow failure costs the user as much as a slow success.
+// Values are in milliseconds.
+type Latencies struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+func Summarize(mode string, results []Result, elapsed time.Duration) Report {
+	report := Report{
+		Mode:        mode,
+		Requests:    len(results),
+		Elapsed:     elapsed.Seconds(),
+		BonusStatus: make(map[string]int),
+		Errors:      make(map[string]int),
+	}
+
+	latencies := make([]time.Duration, 0, len(results))
+	var searchLatencies []time.Duration
+	for _, result := range results {
+		latencies = append(latencies, result.Latency)
+		if result.Success {
+			report.Succeeded++
+			report.BonusStatus[result.BonusStatus]++
+		} else {
+			report.Errors[result.Class]++
+		}
+
+		if search := result.Search; search != nil {
+			if report.Searches == nil {
+				report.Searches = &SearchReport{Errors: make(map[string]int)}
+			}
+			report.Searches.Requests++
+			searchLatencies = append(searchLatencies, search.Latency)
+			if search.Success {
+				report.Searches.Succeeded++
+			} else {
+				report.Searches.Errors[search.Class]++
+			}
+		}
+	}
+	if len(results) > 0 {
+		report.SuccessRate = float64(report.Succeeded) / float64(len(results))
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+	report.Latency = summarizeLatencies(latencies)
+
+	if report.Searches != nil {
+		report.Searches.SuccessRate = float64(report.Searches.Succeeded) / float64(report.Searches.Requests)
+		report.Searches.Latency = summarizeLatencies(searchLatencies)
+	}
+	return report
+}
+
+func summarizeLatencies(latencies []time.Duration) Latencies {
+	slices.Sort(latencies)
+	return Latencies{
+		P50: percentile(latencies, 50),
+		P90: percentile(latencies, 90),
+		P95: percentile(latencies, 95),
+		P99: percentile(latencies, 99),
+		Max: percentile(latencies, 100),
+	}
+}
+
+func percentile(sorted []time.Duration, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return float64(sorted[max(rank, 1)-1].Microseconds()) / 1000
+}
+
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "mode %s: %d requests in %.1fs (%.1f req/s)\n", r.Mode, r.Requests, r.Elapsed, r.Throughput)
+	fmt.Fprintf(w, "  success      %d/%d (%.1f%%)\n", r.Succeeded, r.Requests, 100*r.SuccessRate)
+	fmt.Fprintf(w, "  latency ms   p50 %.1f  p90 %.1f  p95 %.1f  p99 %.1f  max %.1f\n",
+		r.Latency.P50, r.Latency.P90, r.Latency.P95, r.Latency.P99, r.Latency.Max)
+	printCounts(w, "bonus_status", r.BonusStatus)
+	printCounts(w, "errors", r.Errors)
+
+	if s := r.Searches; s != nil {
+		fmt.Fprintf(w, "  searches     %d/%d (%.1f%%)\n", s.Succeeded, s.Requests, 100*s.SuccessRate)
+		fmt.Fprintf(w, "  search ms    p50 %.1f  p90 %.1f  p95 %.1f  p99 %.1f  max %.1f\n",
+			s.Latency.P50, s.Latency.P90, s.Latency.P95, s.Latency.P99, s.Latency.Max)
+		printCounts(w, "search errors", s.Errors)
+	}
+}
+
+func printCounts(w io.Writer, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %s\n", title)
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(w, "    %6d  %s\n", counts[key], key)
+	}
+}
@@ -0,0 +1,74 @@
+package load
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		sorted := make([]time.Duration, len(values))
+		for i, v := range values {
+			sorted[i] = time.Duration(v) * time.Millisecond
+		}
+		return sorted
+	}
+
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      int
+		want   float64
+	}{
+		{"no requests", nil, 50, 0},
+		{"one request p50", ms(7), 50, 7},
+		{"one request p99", ms(7), 99, 7},
+		{"p50 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 50, 5},
+		{"p90 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 90, 9},
+		{"p99 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 99, 10},
+		{"p100 is the max", ms(1, 2, 3), 100, 3},
+		{"p0 is the min", ms(1, 2, 3), 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Fatalf("percentile %d = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := []Result{
+		{Latency: 30 * time.Millisecond, Success: true, BonusStatus: "credited"},
+		{Latency: 10 * time.Millisecond, Success: true, BonusStatus: "pending"},
+		{Latency: 20 * time.Millisecond, Success: true, BonusStatus: "credited"},
+		{Latency: 40 * time.Millisecond, Class: "503 buy"},
+	}
+	report := Summarize(FTOn, results, 2*time.Second)
+
+	if report.Mode != FTOn || report.Requests != 4 || report.Succeeded != 3 {
+		t.Fatalf("got mode %s, %d/%d succeeded; want on, 3/4", report.Mode, report.Succeeded, report.Requests)
+	}
+	if report.SuccessRate != 0.75 || report.Throughput != 2 {
+		t.Fatalf("success rate %v, throughput %v; want 0.75, 2", report.SuccessRate, report.Throughput)
+	}
+	if want := map[string]int{"credited": 2, "pending": 1}; !maps.Equal(report.BonusStatus, want) {
+		t.Fatalf("bonus_status %v, want %v", report.BonusStatus, want)
+	}
+	if want := map[string]int{"503 buy": 1}; !maps.Equal(report.Errors, want) {
+		t.Fatalf("errors %v, want %v", report.Errors, want)
+	}
+	// Failed requests count in the latencies too.
+	if report.Latency.P50 != 20 || report.Latency.Max != 40 {
+		t.Fatalf("latency p50 %v, max %v; want 20, 40", report.Latency.P50, report.Latency.Max)
+	}
+}
+
+func TestSummarizeWithoutResults(t *testing.T) {
+	report := Summarize(FTOff, nil, 0)
+	if report.Requests != 0 || report.SuccessRate != 0 || report.Throughput != 0 || report.Latency != (Latencies{}) {
+		t.Fatalf("empty run summarized as %+v", report)
+	}
+}
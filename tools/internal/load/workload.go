@@ -0,0 +1,62 @@
+package load
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Purchase is one line of a workload file, in the body format of /buyTicket.
+// Lines without flight, day and user are skipped.
+type Purchase struct {
+	Flight    string `json:"flight"`
+	Day       string `json:"day"`
+	User      string `json:"user"`
+	Cabin     string `json:"cabin,omitempty"`
+	FT        bool   `json:"ft,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Search, when set, is the /searchFlights query the user runs before
+	// buying, such as {"origin": "JFK", "destination": "GRU"}. It is not
+	// sent to /buyTicket.
+	Search map[string]string `json:"search,omitempty"`
+}
+
+// ReadWorkload reads the purchases in a JSONL file and reports how many
+// non-empty lines were not purchases.
+func ReadWorkload(path string) ([]Purchase, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var purchases []Purchase
+	skipped := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var purchase Purchase
+		if err := json.Unmarshal([]byte(text), &purchase); err != nil {
+			return nil, 0, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if purchase.Flight == "" || purchase.Day == "" || purchase.User == "" {
+			skipped++
+			continue
+		}
+		purchases = append(purchases, purchase)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(purchases) == 0 {
+		return nil, skipped, fmt.Errorf("%s: no purchases found", path)
+	}
+	return purchases, skipped, nil
+}
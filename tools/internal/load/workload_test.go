@@ -0,0 +1,60 @@
+package load
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeWorkload(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "purchases.jsonl")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadWorkload(t *testing.T) {
+	path := writeWorkload(t, `{"flight": "AA123", "day": "2025-11-15", "user": "u1", "ft": true, "request_id": "r1"}
+
+{"flight": "AA123", "day": "2025-11-15"}
+{"flight": "AA456", "day": "2025-11-20", "user": "u2"}
+`)
+
+	purchases, skipped, err := ReadWorkload(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Purchase{
+		{Flight: "AA123", Day: "2025-11-15", User: "u1", FT: true, RequestID: "r1"},
+		{Flight: "AA456", Day: "2025-11-20", User: "u2"},
+	}
+	if !slices.EqualFunc(purchases, want, func(a, b Purchase) bool {
+		return a.Flight == b.Flight && a.Day == b.Day && a.User == b.User && a.FT == b.FT && a.RequestID == b.RequestID
+	}) {
+		t.Fatalf("purchases %+v, want %+v", purchases, want)
+	}
+	if skipped != 1 {
+		t.Fatalf("skipped %d lines, want 1", skipped)
+	}
+}
+
+func TestReadWorkloadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid line", "{\"flight\": \"AA123\", \"day\": \"2025-11-15\", \"user\": \"u1\"}\nnot json\n"},
+		{"no purchases", "{\"flight\": \"AA123\"}\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ReadWorkload(writeWorkload(t, tt.content)); err == nil {
+				t.Fatal("workload read without an error")
+			}
+		})
+	}
+}